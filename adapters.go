@@ -2,8 +2,10 @@ package instana
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"runtime"
+	"strings"
 
 	"github.com/felixge/httpsnoop"
 	ot "github.com/opentracing/opentracing-go"
@@ -11,6 +13,19 @@ import (
 	otlog "github.com/opentracing/opentracing-go/log"
 )
 
+// Headers used to pass the trace ID to the browser
+const (
+	serverTimingHeader        = "Server-Timing"
+	serverTimingTraceIDMetric = "intid"
+	timingAllowOriginHeader   = "Timing-Allow-Origin"
+)
+
+// Tags used to report HTTP request details not covered by the OpenTracing semantic conventions
+const (
+	httpParamsTag       = "http.params"
+	httpHeaderTagPrefix = "http.header."
+)
+
 type TracerSensitiveFunc func(tracer ot.Tracer)
 type SpanSensitiveFunc func(span ot.Span)
 type ContextSensitiveFunc func(span ot.Span, ctx context.Context)
@@ -31,6 +46,11 @@ func NewSensor(serviceName string) *Sensor {
 	}
 }
 
+// NewSensorWithTracer returns a sensor instance that uses given tracer to trace requests
+func NewSensorWithTracer(tracer ot.Tracer) *Sensor {
+	return &Sensor{tracer}
+}
+
 // Enables access to the sensor internal tracer for more complex scenarios, where additional
 // frameworks or integrations are created.
 func (s *Sensor) WithTracer(f TracerSensitiveFunc) {
@@ -50,14 +70,43 @@ func (s *Sensor) TraceHandler(name, pattern string, handler http.HandlerFunc) (s
 func (s *Sensor) TracingHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		s.WithTracingContext(name, w, req, func(span ot.Span, ctx context.Context) {
+			// Response headers are sent along with the status code or the first chunk of the body,
+			// whatever comes first, so this is the last chance to add the trace ID to them
+			var headersAdded bool
+			addHeaders := func() {
+				if !headersAdded {
+					headersAdded = true
+					addServerTimingHeaders(w.Header(), span)
+				}
+			}
+
 			// Capture response code for span
 			hooks := httpsnoop.Hooks{
 				WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
 					return func(code int) {
+						addHeaders()
 						next(code)
 						span.SetTag(string(ext.HTTPStatusCode), code)
 					}
 				},
+				Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						addHeaders()
+						return next(b)
+					}
+				},
+				ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						addHeaders()
+						return next(src)
+					}
+				},
+				Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+					return func() {
+						addHeaders()
+						next()
+					}
+				},
 			}
 
 			// Add hooks to response writer
@@ -69,6 +118,41 @@ func (s *Sensor) TracingHandler(name string, handler http.HandlerFunc) http.Hand
 	}
 }
 
+// addServerTimingHeaders adds the trace ID to the Server-Timing response header if enabled with
+// Options.ServerTiming, so that the browser can correlate the page load with the backend trace.
+// The values set by the handler are preserved.
+func addServerTimingHeaders(h http.Header, span ot.Span) {
+	if sensor == nil || !sensor.options.ServerTiming {
+		return
+	}
+
+	sc, ok := span.Context().(SpanContext)
+	if !ok {
+		return
+	}
+
+	traceID, err := ID2Header(sc.TraceID)
+	if err != nil {
+		return
+	}
+
+	for _, v := range h[serverTimingHeader] {
+		if strings.Contains(v, serverTimingTraceIDMetric+";") {
+			return
+		}
+	}
+	h.Add(serverTimingHeader, serverTimingTraceIDMetric+";desc="+traceID)
+
+	if h.Get(timingAllowOriginHeader) == "" {
+		origin := sensor.options.TimingAllowOrigin
+		if origin == "" {
+			origin = "*"
+		}
+
+		h.Set(timingAllowOriginHeader, origin)
+	}
+}
+
 // Wraps an existing http.Request instance into a named instance to inject tracing and span
 // header information into the actual HTTP wire transfer.
 func (s *Sensor) TracingHttpRequest(name string, parent, req *http.Request, client http.Client) (res *http.Response, err error) {
@@ -87,10 +171,22 @@ func (s *Sensor) TracingHttpRequest(name string, parent, req *http.Request, clie
 
 	res, err = client.Do(req.WithContext(context.Background()))
 
+	cfg := currentAgentConfig()
+
+	// Query parameters are reported separately to have the secrets redacted
+	u := *req.URL
+	u.RawQuery = ""
+
 	span.SetTag(string(ext.SpanKind), string(ext.SpanKindRPCClientEnum))
 	span.SetTag(string(ext.PeerHostname), req.Host)
-	span.SetTag(string(ext.HTTPUrl), req.URL.String())
+	span.SetTag(string(ext.HTTPUrl), u.String())
 	span.SetTag(string(ext.HTTPMethod), req.Method)
+	if req.URL.RawQuery != "" {
+		span.SetTag(httpParamsTag, cfg.secrets.redactQuery(req.URL.RawQuery))
+	}
+	for k, v := range cfg.collectHTTPHeaders(req.Header) {
+		span.SetTag(httpHeaderTagPrefix+k, v)
+	}
 	span.SetTag(string(ext.HTTPStatusCode), res.StatusCode)
 
 	if err != nil {
@@ -130,6 +226,14 @@ func (s *Sensor) WithTracingSpan(name string, w http.ResponseWriter, req *http.R
 	span.SetTag(string(ext.HTTPUrl), req.URL.Path)
 	span.SetTag(string(ext.HTTPMethod), req.Method)
 
+	cfg := currentAgentConfig()
+	if req.URL.RawQuery != "" {
+		span.SetTag(httpParamsTag, cfg.secrets.redactQuery(req.URL.RawQuery))
+	}
+	for k, v := range cfg.collectHTTPHeaders(req.Header) {
+		span.SetTag(httpHeaderTagPrefix+k, v)
+	}
+
 	defer func() {
 		// Capture outgoing headers
 		s.tracer.Inject(span.Context(), ot.HTTPHeaders, ot.HTTPHeadersCarrier(w.Header()))
@@ -144,6 +248,11 @@ func (s *Sensor) WithTracingSpan(name string, w http.ResponseWriter, req *http.R
 			} else {
 				span.LogFields(otlog.Object("error", err))
 			}
+
+			if sensor != nil {
+				sensor.agent.lifecycle.panicked(name, err)
+			}
+
 			panic(err)
 		}
 	}()
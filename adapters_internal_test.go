@@ -0,0 +1,74 @@
+package instana
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveWithServerTiming(t *testing.T, enabled bool, allowOrigin string, handler http.HandlerFunc) (*http.Response, string) {
+	InitSensor(&Options{})
+
+	prevEnabled, prevOrigin := sensor.options.ServerTiming, sensor.options.TimingAllowOrigin
+	defer func() { sensor.options.ServerTiming, sensor.options.TimingAllowOrigin = prevEnabled, prevOrigin }()
+
+	sensor.options.ServerTiming, sensor.options.TimingAllowOrigin = enabled, allowOrigin
+
+	recorder := NewTestRecorder()
+	s := &Sensor{tracer: NewTracerWithEverything(&Options{}, recorder)}
+
+	rec := httptest.NewRecorder()
+	s.TracingHandler("test", handler)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	traceID, err := ID2Header(spans[0].TraceID)
+	require.NoError(t, err)
+
+	return rec.Result(), traceID
+}
+
+func TestTracingHandler_ServerTiming(t *testing.T) {
+	resp, traceID := serveWithServerTiming(t, true, "", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=53")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []string{"db;dur=53", "intid;desc=" + traceID}, resp.Header["Server-Timing"])
+	assert.Equal(t, "*", resp.Header.Get("Timing-Allow-Origin"))
+}
+
+func TestTracingHandler_ServerTiming_ImplicitHeader(t *testing.T) {
+	resp, traceID := serveWithServerTiming(t, true, "https://www.example.com", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello"))
+	})
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"intid;desc=" + traceID}, resp.Header["Server-Timing"])
+	assert.Equal(t, "https://www.example.com", resp.Header.Get("Timing-Allow-Origin"))
+}
+
+func TestTracingHandler_ServerTiming_PreservesHandlerValues(t *testing.T) {
+	resp, _ := serveWithServerTiming(t, true, "", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server-Timing", "intid;desc=custom")
+		w.Header().Set("Timing-Allow-Origin", "https://www.example.com")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	assert.Equal(t, []string{"intid;desc=custom"}, resp.Header["Server-Timing"])
+	assert.Equal(t, "https://www.example.com", resp.Header.Get("Timing-Allow-Origin"))
+}
+
+func TestTracingHandler_ServerTiming_Disabled(t *testing.T) {
+	resp, _ := serveWithServerTiming(t, false, "", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello"))
+	})
+
+	assert.Empty(t, resp.Header.Get("Server-Timing"))
+	assert.Empty(t, resp.Header.Get("Timing-Allow-Origin"))
+}
@@ -2,13 +2,14 @@ package instana
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
-	"time"
 )
 
 const (
@@ -22,16 +23,34 @@ const (
 )
 
 type agentResponse struct {
-	Pid    uint32 `json:"pid"`
-	HostID string `json:"agentUuid"`
+	Pid              uint32         `json:"pid"`
+	HostID           string         `json:"agentUuid"`
+	Secrets          secretsMatcher `json:"secrets"`
+	ExtraHTTPHeaders []string       `json:"extraHeaders"`
+	Tracing          struct {
+		ExtraHTTPHeaders []string `json:"extra-http-headers"`
+	} `json:"tracing"`
 }
 
 type discoveryS struct {
-	PID   int      `json:"pid"`
-	Name  string   `json:"name"`
-	Args  []string `json:"args"`
-	Fd    string   `json:"fd"`
-	Inode string   `json:"inode"`
+	PID          int      `json:"pid"`
+	PIDFrom      string   `json:"pidFrom,omitempty"`
+	Name         string   `json:"name"`
+	Args         []string `json:"args"`
+	Fd           string   `json:"fd"`
+	Inode        string   `json:"inode"`
+	ContainerID  string   `json:"containerId,omitempty"`
+	PIDNamespace uint64   `json:"pidNamespace,omitempty"`
+}
+
+// agentStatusError is returned when the agent responds with a non-2xx status code
+type agentStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *agentStatusError) Error() string {
+	return e.Status
 }
 
 type fromS struct {
@@ -40,46 +59,103 @@ type fromS struct {
 }
 
 type agentS struct {
-	sensor *sensorS
-	fsm    *fsmS
-	from   *fromS
-	host   string
-	client *http.Client
+	sensor       *sensorS
+	fsm          *fsmS
+	from         *fromS
+	host         string
+	hostEndpoint *agentEndpoint
+	client       *http.Client
+	config       agentConfigHolder
+	endpoint     *serverlessEndpoint
+	transport    Transport
+	events       *sender
+	lifecycle    *lifecycleEvents
+
+	gzipState int32
 }
 
 func (r *agentS) init() {
-	r.client = &http.Client{Timeout: 5 * time.Second}
-	r.fsm = r.initFsm()
+	r.hostEndpoint = newAgentEndpoint(r.sensor.options)
+	r.client = newAgentClient(r.hostEndpoint, r.sensor.options.AgentTLSConfig)
+	r.transport = r.sensor.options.Transport
+	r.events = newSender("event", eventsQueueSize, r.sendEvent)
+	r.lifecycle = newLifecycleEvents(r.sensor.options.LifecycleEvents, r.sensor.serviceName, r)
+
+	if r.transport == nil {
+		r.transport = &agentTransport{agent: r}
+
+		// In serverless mode there is no host agent to discover and announce to
+		if r.endpoint = newServerlessEndpointFromEnv(); r.endpoint != nil {
+			log.info("Reporting directly to the serverless endpoint", r.endpoint.url)
+			r.setFrom(&fromS{PID: strconv.Itoa(os.Getpid())})
+
+			return
+		}
+	}
+
 	r.setFrom(&fromS{})
+	r.initFsm()
+
+	if r.usesHostAgent() {
+		go r.pollRequests()
+	}
+}
+
+// usesHostAgent returns true if the data is sent to the host agent over HTTP, i.e.
+// the transport is neither custom nor in serverless mode
+func (r *agentS) usesHostAgent() bool {
+	_, ok := r.transport.(*agentTransport)
+
+	return ok && r.endpoint == nil
 }
 
 func (r *agentS) makeURL(prefix string) string {
+	if r.endpoint != nil {
+		return r.endpoint.makeURL(prefix)
+	}
+
 	return r.makeHostURL(r.host, prefix)
 }
 
 func (r *agentS) makeHostURL(host string, prefix string) string {
-	envPort := os.Getenv("INSTANA_AGENT_PORT")
-	port := agentDefaultPort
+	return r.makeFullURL(host, r.port(), prefix)
+}
+
+// port returns the agent port taken from the configured endpoint, Options.AgentPort
+// or INSTANA_AGENT_PORT env variable in this order
+func (r *agentS) port() int {
+	if r.hostEndpoint != nil {
+		return r.hostEndpoint.port
+	}
+
 	if r.sensor.options.AgentPort > 0 {
-		return r.makeFullURL(host, r.sensor.options.AgentPort, prefix)
+		return r.sensor.options.AgentPort
 	}
+
+	envPort := os.Getenv("INSTANA_AGENT_PORT")
 	if envPort == "" {
-		return r.makeFullURL(host, port, prefix)
+		return agentDefaultPort
 	}
+
 	port, err := strconv.Atoi(envPort)
 	if err != nil {
-		return r.makeFullURL(host, agentDefaultPort, prefix)
+		return agentDefaultPort
 	}
-	return r.makeFullURL(host, port, prefix)
+
+	return port
 }
 
 func (r *agentS) makeFullURL(host string, port int, prefix string) string {
 	var buffer bytes.Buffer
 
-	buffer.WriteString("http://")
-	buffer.WriteString(host)
-	buffer.WriteString(":")
-	buffer.WriteString(strconv.Itoa(port))
+	scheme := "http"
+	if r.hostEndpoint != nil {
+		scheme = r.hostEndpoint.scheme
+	}
+
+	buffer.WriteString(scheme)
+	buffer.WriteString("://")
+	buffer.WriteString(net.JoinHostPort(host, strconv.Itoa(port)))
 	buffer.WriteString(prefix)
 	if prefix[len(prefix)-1:] == "." && r.from.PID != "" {
 		buffer.WriteString(r.from.PID)
@@ -88,10 +164,6 @@ func (r *agentS) makeFullURL(host string, port int, prefix string) string {
 	return buffer.String()
 }
 
-func (r *agentS) head(url string) (string, error) {
-	return r.request(url, "HEAD", nil)
-}
-
 func (r *agentS) request(url string, method string, data interface{}) (string, error) {
 	return r.fullRequestResponse(url, method, data, nil, "")
 }
@@ -104,44 +176,75 @@ func (r *agentS) requestHeader(url string, method string, header string) (string
 	return r.fullRequestResponse(url, method, nil, nil, header)
 }
 
+func (r *agentS) requestContext(ctx context.Context, url string, method string, data interface{}) (string, error) {
+	return r.fullRequestResponseContext(ctx, url, method, data, nil, "")
+}
+
 func (r *agentS) fullRequestResponse(url string, method string, data interface{}, body interface{}, header string) (string, error) {
+	return r.fullRequestResponseContext(context.Background(), url, method, data, body, header)
+}
+
+func (r *agentS) fullRequestResponseContext(ctx context.Context, url string, method string, data interface{}, body interface{}, header string) (string, error) {
 	var j []byte
-	var ret string
 	var err error
-	var resp *http.Response
-	var req *http.Request
 	if data != nil {
 		j, err = json.Marshal(data)
 	}
 
+	if err != nil {
+		log.info(err, url)
+		return "", err
+	}
+
+	return r.rawRequestResponse(ctx, url, method, j, "", body, header)
+}
+
+// rawRequestResponse sends the already encoded JSON payload to the agent. If contentEncoding is not
+// empty, it is sent as the Content-Encoding header value.
+func (r *agentS) rawRequestResponse(ctx context.Context, url string, method string, j []byte, contentEncoding string, body interface{}, header string) (string, error) {
+	// Uncomment this to dump json payloads
+	// log.debug(bytes.NewBuffer(j))
+
+	var payload io.Reader
+	if j != nil {
+		payload = bytes.NewReader(j)
+	}
+
+	return r.streamRequestResponse(ctx, url, method, payload, contentEncoding, body, header)
+}
+
+// streamRequestResponse sends the JSON payload read from the provided reader to the agent. If
+// contentEncoding is not empty, it is sent as the Content-Encoding header value.
+func (r *agentS) streamRequestResponse(ctx context.Context, url string, method string, payload io.Reader, contentEncoding string, body interface{}, header string) (string, error) {
+	var ret string
+	var resp *http.Response
+
+	req, err := http.NewRequest(method, url, payload)
+
 	if err == nil {
-		if j != nil {
-			req, err = http.NewRequest(method, url, bytes.NewBuffer(j))
-		} else {
-			req, err = http.NewRequest(method, url, nil)
+		req.Header.Set("Content-Type", "application/json")
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		if r.endpoint != nil {
+			r.endpoint.setHeaders(req.Header, r.clock().Now())
 		}
 
-		// Uncomment this to dump json payloads
-		// log.debug(bytes.NewBuffer(j))
-
+		resp, err = r.client.Do(req.WithContext(ctx))
 		if err == nil {
-			req.Header.Set("Content-Type", "application/json")
-			resp, err = r.client.Do(req)
-			if err == nil {
-				defer resp.Body.Close()
-
-				if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-					err = errors.New(resp.Status)
-				} else {
-					if body != nil {
-						var b []byte
-						b, err = ioutil.ReadAll(resp.Body)
-						json.Unmarshal(b, body)
-					}
-
-					if header != "" {
-						ret = resp.Header.Get(header)
-					}
+			defer resp.Body.Close()
+
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				err = &agentStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+			} else {
+				if body != nil {
+					var b []byte
+					b, err = ioutil.ReadAll(resp.Body)
+					json.Unmarshal(b, body)
+				}
+
+				if header != "" {
+					ret = resp.Header.Get(header)
 				}
 			}
 		}
@@ -151,7 +254,7 @@ func (r *agentS) fullRequestResponse(url string, method string, data interface{}
 		// Ignore errors while in announced stated (before ready) as
 		// this is the time where the entity is registering in the Instana
 		// backend and it will return 404 until it's done.
-		if !r.sensor.agent.fsm.fsm.Is("announced") {
+		if r.fsm == nil || !r.fsm.fsm.Is("announced") {
 			log.info(err, url)
 		}
 	}
@@ -163,11 +266,35 @@ func (r *agentS) setFrom(from *fromS) {
 	r.from = from
 }
 
+func (r *agentS) sendEvent(event interface{}) {
+	if err := deliverEvent(context.Background(), r.clock(), r.transport, event.(*EventData)); err != nil {
+		log.debug("failed to send event:", err)
+	}
+}
+
+// clock returns the clock configured for the sensor, or the SystemClock if there is none
+func (r *agentS) clock() Clock {
+	if r.sensor == nil || r.sensor.options == nil || r.sensor.options.Clock == nil {
+		return SystemClock
+	}
+
+	return r.sensor.options.Clock
+}
+
+func (r *agentS) applyConfig(resp *agentResponse) {
+	r.config.set(newAgentConfig(resp))
+}
+
 func (r *agentS) setHost(host string) {
 	r.host = host
 }
 
 func (r *agentS) reset() {
+	// There is no announcement to be reset in serverless mode
+	if r.fsm == nil {
+		return
+	}
+
 	r.fsm.reset()
 }
 
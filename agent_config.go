@@ -0,0 +1,76 @@
+package instana
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// agentConfig holds the configuration provided by the host agent in response
+// to the announce request.
+type agentConfig struct {
+	secrets          secretsMatcher
+	extraHTTPHeaders []string
+}
+
+var defaultAgentConfig = &agentConfig{secrets: defaultSecretsMatcher}
+
+func newAgentConfig(resp *agentResponse) *agentConfig {
+	cfg := &agentConfig{secrets: defaultSecretsMatcher}
+
+	if resp.Secrets.Matcher != "" {
+		cfg.secrets = secretsMatcher{
+			Matcher: resp.Secrets.Matcher,
+			List:    resp.Secrets.List,
+		}.compile()
+	}
+
+	// Older agents send the list of headers to capture as a top-level field
+	cfg.extraHTTPHeaders = resp.Tracing.ExtraHTTPHeaders
+	if len(cfg.extraHTTPHeaders) == 0 {
+		cfg.extraHTTPHeaders = resp.ExtraHTTPHeaders
+	}
+
+	return cfg
+}
+
+// collectHTTPHeaders returns the values of headers configured to be captured by the agent
+func (c *agentConfig) collectHTTPHeaders(h http.Header) map[string]string {
+	if len(c.extraHTTPHeaders) == 0 {
+		return nil
+	}
+
+	ret := make(map[string]string)
+	for _, name := range c.extraHTTPHeaders {
+		if v := h.Get(name); v != "" {
+			ret[name] = v
+		}
+	}
+
+	return ret
+}
+
+type agentConfigHolder struct {
+	v atomic.Value
+}
+
+func (r *agentConfigHolder) set(cfg *agentConfig) {
+	r.v.Store(cfg)
+}
+
+func (r *agentConfigHolder) get() *agentConfig {
+	if cfg, ok := r.v.Load().(*agentConfig); ok {
+		return cfg
+	}
+
+	return defaultAgentConfig
+}
+
+// currentAgentConfig returns the latest configuration received from the host agent or
+// the default one if the sensor is not initialized or has not yet been announced.
+func currentAgentConfig() *agentConfig {
+	if sensor == nil || sensor.agent == nil {
+		return defaultAgentConfig
+	}
+
+	return sensor.agent.config.get()
+}
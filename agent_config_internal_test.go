@@ -0,0 +1,48 @@
+package instana
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAgentConfig(t *testing.T) {
+	var resp agentResponse
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "pid": 37892,
+	  "agentUuid": "88:66:5a:ff:fe:05:a5:f0",
+	  "secrets": {"matcher": "equals", "list": ["token"]},
+	  "extraHeaders": ["X-Legacy"],
+	  "tracing": {"extra-http-headers": ["X-Request-Id", "X-Tenant"]}
+	}`), &resp))
+
+	cfg := newAgentConfig(&resp)
+
+	assert.Equal(t, SecretsMatcherEquals, cfg.secrets.Matcher)
+	assert.True(t, cfg.secrets.Match("token"))
+	assert.False(t, cfg.secrets.Match("key"))
+
+	h := http.Header{}
+	h.Set("X-Request-Id", "abc")
+	h.Set("X-Legacy", "def")
+	assert.Equal(t, map[string]string{"X-Request-Id": "abc"}, cfg.collectHTTPHeaders(h))
+}
+
+func TestNewAgentConfigDefaults(t *testing.T) {
+	cfg := newAgentConfig(&agentResponse{ExtraHTTPHeaders: []string{"X-Legacy"}})
+
+	assert.Equal(t, defaultSecretsMatcher, cfg.secrets)
+	assert.Equal(t, []string{"X-Legacy"}, cfg.extraHTTPHeaders)
+}
+
+func TestAgentConfigHolder(t *testing.T) {
+	var h agentConfigHolder
+	assert.Equal(t, defaultAgentConfig, h.get())
+
+	cfg := &agentConfig{extraHTTPHeaders: []string{"X-Tenant"}}
+	h.set(cfg)
+	assert.Equal(t, cfg, h.get())
+}
@@ -0,0 +1,137 @@
+package instana
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// agentEndpoint is the host agent location configured via Options.AgentEndpoint or the
+// INSTANA_AGENT_ENDPOINT env variable. Supported formats are:
+//
+//	http://host[:port]
+//	https://host[:port]
+//	unix:///path/to/agent.sock
+//
+// If the port is omitted, the default agent port 42699 is used.
+type agentEndpoint struct {
+	scheme string
+	host   string
+	port   int
+	socket string
+}
+
+func parseAgentEndpoint(s string) (*agentEndpoint, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, errors.New("missing socket path in " + s)
+		}
+
+		// The host part is only used to build request URLs, all connections
+		// are made to the socket
+		return &agentEndpoint{
+			scheme: "http",
+			host:   agentDefaultHost,
+			port:   agentDefaultPort,
+			socket: u.Path,
+		}, nil
+	case "http", "https":
+		if u.Hostname() == "" {
+			return nil, errors.New("missing host in " + s)
+		}
+
+		ep := &agentEndpoint{
+			scheme: u.Scheme,
+			host:   u.Hostname(),
+			port:   agentDefaultPort,
+		}
+
+		if p := u.Port(); p != "" {
+			if ep.port, err = strconv.Atoi(p); err != nil {
+				return nil, err
+			}
+		}
+
+		return ep, nil
+	default:
+		return nil, errors.New("unsupported agent endpoint scheme " + u.Scheme)
+	}
+}
+
+// newAgentEndpoint returns the agent endpoint configured by the user or nil
+// if the agent host needs to be discovered
+func newAgentEndpoint(opts *Options) *agentEndpoint {
+	s := opts.AgentEndpoint
+	if s == "" {
+		s = os.Getenv("INSTANA_AGENT_ENDPOINT")
+	}
+
+	if s == "" {
+		return nil
+	}
+
+	ep, err := parseAgentEndpoint(s)
+	if err != nil {
+		log.error("invalid agent endpoint, falling back to agent host discovery:", err)
+		return nil
+	}
+
+	return ep
+}
+
+// dial opens a connection to the agent. The host is only used for TCP endpoints.
+func (ep *agentEndpoint) dial(ctx context.Context, host string, port int) (net.Conn, error) {
+	var d net.Dialer
+
+	if ep != nil && ep.socket != "" {
+		return d.DialContext(ctx, "unix", ep.socket)
+	}
+
+	return d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// newAgentClient returns an HTTP client to talk to the agent through the configured endpoint.
+// The TLS config is only used for https:// endpoints, since the agent discovered on the
+// host or the default gateway is always reached via plain HTTP.
+func newAgentClient(ep *agentEndpoint, tlsConfig *tls.Config) *http.Client {
+	if tlsConfig != nil && (ep == nil || ep.scheme != "https") {
+		log.warn("agent TLS config is ignored, since the agent endpoint is not an https:// URL")
+		tlsConfig = nil
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	if ep == nil || (ep.socket == "" && tlsConfig == nil) {
+		return client
+	}
+
+	tr := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     tlsConfig,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+
+	if ep.socket != "" {
+		tr.Proxy = nil
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return ep.dial(ctx, "", 0)
+		}
+	}
+
+	client.Transport = tr
+
+	return client
+}
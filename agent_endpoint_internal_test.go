@@ -0,0 +1,135 @@
+package instana
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAgentEndpoint(t *testing.T) {
+	tests := map[string]*agentEndpoint{
+		"http://agent":                 {scheme: "http", host: "agent", port: 42699},
+		"http://10.0.0.1:8080":         {scheme: "http", host: "10.0.0.1", port: 8080},
+		"https://agent.example.com":    {scheme: "https", host: "agent.example.com", port: 42699},
+		"https://[::1]:443":            {scheme: "https", host: "::1", port: 443},
+		"unix:///var/run/instana.sock": {scheme: "http", host: "localhost", port: 42699, socket: "/var/run/instana.sock"},
+	}
+
+	for s, expected := range tests {
+		t.Run(s, func(t *testing.T) {
+			ep, err := parseAgentEndpoint(s)
+			require.NoError(t, err)
+			assert.Equal(t, expected, ep)
+		})
+	}
+
+	for _, s := range []string{"ftp://agent", "http://", "unix://", "http://agent:port"} {
+		t.Run(s, func(t *testing.T) {
+			_, err := parseAgentEndpoint(s)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAgentMakeURLWithEndpoint(t *testing.T) {
+	ep, err := parseAgentEndpoint("https://agent.example.com:8443")
+	require.NoError(t, err)
+
+	agent := &agentS{
+		sensor:       &sensorS{options: &Options{AgentPort: 1234}},
+		from:         &fromS{PID: "42"},
+		host:         ep.host,
+		hostEndpoint: ep,
+	}
+
+	assert.Equal(t, "https://agent.example.com:8443/com.instana.plugin.golang.42", agent.makeURL(agentDataURL))
+}
+
+func TestAgentClientUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agentsocket")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", agentHeader)
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	ep, err := parseAgentEndpoint("unix://" + socket)
+	require.NoError(t, err)
+
+	agent := &agentS{
+		sensor:       &sensorS{options: &Options{}},
+		from:         &fromS{},
+		hostEndpoint: ep,
+		client:       newAgentClient(ep, nil),
+	}
+
+	header, err := agent.requestHeader(agent.makeHostURL(ep.host, "/"), "GET", "Server")
+	require.NoError(t, err)
+	assert.Equal(t, agentHeader, header)
+
+	conn, err := ep.dial(context.Background(), "", 0)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestAgentClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server", agentHeader)
+	}))
+	defer srv.Close()
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	ep, err := parseAgentEndpoint("https://" + net.JoinHostPort(host, port))
+	require.NoError(t, err)
+
+	p, _ := strconv.Atoi(port)
+	assert.Equal(t, p, ep.port)
+
+	agent := &agentS{
+		sensor:       &sensorS{options: &Options{}},
+		from:         &fromS{},
+		hostEndpoint: ep,
+		client:       newAgentClient(ep, srv.Client().Transport.(*http.Transport).TLSClientConfig),
+	}
+
+	header, err := agent.requestHeader(agent.makeHostURL(ep.host, "/"), "GET", "Server")
+	require.NoError(t, err)
+	assert.Equal(t, agentHeader, header)
+}
+
+func TestAgentClientTLS_NoHTTPSEndpoint(t *testing.T) {
+	ep, err := parseAgentEndpoint("http://localhost:42699")
+	require.NoError(t, err)
+
+	examples := map[string]*agentEndpoint{
+		"host discovery": nil,
+		"http endpoint":  ep,
+	}
+
+	for name, ep := range examples {
+		t.Run(name, func(t *testing.T) {
+			client := newAgentClient(ep, &tls.Config{ServerName: "agent.example.com"})
+			assert.Nil(t, client.Transport)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package instana
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	agentRequestsURL  = "/com.instana.plugin.golang/request."
+	agentResponseURL  = "/com.instana.plugin.golang/response."
+	agentPollInterval = 1 * time.Second
+	agentPollBackoff  = retryPeriod * time.Millisecond
+)
+
+// AgentRequestHandlerFunc handles an action requested by the host agent. The returned
+// value is serialized to JSON and sent back to the agent along with the error message,
+// if any.
+type AgentRequestHandlerFunc func(args map[string]interface{}) (interface{}, error)
+
+type agentRequest struct {
+	MessageID string                 `json:"messageId"`
+	Action    string                 `json:"action"`
+	Args      map[string]interface{} `json:"args"`
+}
+
+type agentRequestResult struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+var agentRequestHandlers = struct {
+	sync.RWMutex
+	m map[string]AgentRequestHandlerFunc
+}{
+	m: map[string]AgentRequestHandlerFunc{
+		"snapshot": requestSnapshot,
+	},
+}
+
+// HandleAgentRequest registers the handler for an action requested by the host agent. A handler
+// registered for an action that already has one replaces the previous handler.
+func HandleAgentRequest(action string, handler AgentRequestHandlerFunc) {
+	agentRequestHandlers.Lock()
+	defer agentRequestHandlers.Unlock()
+
+	agentRequestHandlers.m[action] = handler
+}
+
+// requestSnapshot makes the meter send the snapshot along with the next metrics payload
+func requestSnapshot(map[string]interface{}) (interface{}, error) {
+	if sensor == nil || sensor.meter == nil {
+		return nil, errors.New("sensor is not initialized")
+	}
+
+	atomic.StoreInt32(&sensor.meter.forceSnapshot, 1)
+
+	return nil, nil
+}
+
+// pollRequests periodically checks the host agent for pending requests once the sensor
+// is announced. If the agent does not respond, polling is suspended for agentPollBackoff.
+func (r *agentS) pollRequests() {
+	ticker := r.clock().NewTicker(agentPollInterval)
+	defer ticker.Stop()
+
+	var suspendedUntil time.Time
+	for t := range ticker.C() {
+		if !r.canSend() || t.Before(suspendedUntil) {
+			continue
+		}
+
+		var reqs []agentRequest
+		if _, err := r.requestResponse(r.makeURL(agentRequestsURL), "GET", nil, &reqs); err != nil {
+			log.debug("failed to poll agent requests, suspending for", agentPollBackoff, err)
+			suspendedUntil = t.Add(agentPollBackoff)
+
+			continue
+		}
+
+		for _, req := range reqs {
+			r.handleRequest(req)
+		}
+	}
+}
+
+func (r *agentS) handleRequest(req agentRequest) {
+	agentRequestHandlers.RLock()
+	handler, ok := agentRequestHandlers.m[req.Action]
+	agentRequestHandlers.RUnlock()
+
+	var res agentRequestResult
+	if !ok {
+		res.Error = "unsupported action " + req.Action
+	} else if data, err := callAgentRequestHandler(handler, req.Args); err != nil {
+		res.Error = err.Error()
+	} else {
+		res.Data = data
+	}
+
+	log.debug("handled agent request", req.Action, req.MessageID, res.Error)
+
+	if _, err := r.request(r.makeURL(agentResponseURL)+"?messageId="+url.QueryEscape(req.MessageID), "POST", res); err != nil {
+		log.debug("failed to send agent request response", req.MessageID, err)
+	}
+}
+
+// callAgentRequestHandler calls the handler recovering from panics, since handlers are run
+// on the background goroutine polling the agent
+func callAgentRequestHandler(handler AgentRequestHandlerFunc, args map[string]interface{}) (data interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("handler panicked: %v", v)
+		}
+	}()
+
+	return handler(args)
+}
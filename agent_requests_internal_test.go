@@ -0,0 +1,96 @@
+package instana
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentHandleRequest(t *testing.T) {
+	InitSensor(&Options{})
+
+	type response struct {
+		messageID string
+		body      map[string]interface{}
+	}
+
+	responses := make(chan response, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/com.instana.plugin.golang/response.1234", req.URL.Path)
+
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(b, &body))
+
+		responses <- response{req.URL.Query().Get("messageId"), body}
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{})
+
+	HandleAgentRequest("test.echo", func(args map[string]interface{}) (interface{}, error) {
+		return args["value"], nil
+	})
+	HandleAgentRequest("test.fail", func(map[string]interface{}) (interface{}, error) {
+		return nil, errors.New("something went wrong")
+	})
+
+	HandleAgentRequest("test.panic", func(map[string]interface{}) (interface{}, error) {
+		panic("boom")
+	})
+
+	agent.handleRequest(agentRequest{MessageID: "1", Action: "test.echo", Args: map[string]interface{}{"value": "hello"}})
+	agent.handleRequest(agentRequest{MessageID: "2", Action: "test.fail"})
+	agent.handleRequest(agentRequest{MessageID: "3", Action: "test.unknown"})
+	agent.handleRequest(agentRequest{MessageID: "4", Action: "test.panic"})
+
+	assert.Equal(t, response{"1", map[string]interface{}{"data": "hello"}}, <-responses)
+	assert.Equal(t, response{"2", map[string]interface{}{"error": "something went wrong"}}, <-responses)
+	assert.Equal(t, response{"3", map[string]interface{}{"error": "unsupported action test.unknown"}}, <-responses)
+	assert.Equal(t, response{"4", map[string]interface{}{"error": "handler panicked: boom"}}, <-responses)
+}
+
+func TestAgentPollRequests(t *testing.T) {
+	var polls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&polls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	clock := newManualClock(time.Now())
+
+	agent := newTestAgent(t, srv, &Options{Clock: clock})
+	agent.endpoint = &serverlessEndpoint{url: srv.URL}
+
+	go agent.pollRequests()
+	require.Eventually(t, func() bool { return clock.Pending() > 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&polls))
+
+	clock.Advance(agentPollInterval)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&polls) == 1 }, time.Second, 10*time.Millisecond)
+
+	// polling is suspended after a failed request
+	time.Sleep(10 * time.Millisecond)
+	clock.Advance(agentPollInterval)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&polls))
+
+	clock.Advance(agentPollBackoff)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&polls) == 2 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,9 @@
+//go:build !go1.18
+// +build !go1.18
+
+package instana
+
+import "runtime/debug"
+
+// setVCSInfo is a no-op, since Go versions prior to 1.18 do not embed VCS information
+func setVCSInfo(info *BuildInfoS, bi *debug.BuildInfo) {}
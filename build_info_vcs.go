@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package instana
+
+import "runtime/debug"
+
+func setVCSInfo(info *BuildInfoS, bi *debug.BuildInfo) {
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.RevisionTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build go1.18
+// +build go1.18
+
+package instana
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetVCSInfo(t *testing.T) {
+	info := &BuildInfoS{Path: "example.com/app"}
+	setVCSInfo(info, &debug.BuildInfo{
+		Settings: []debug.BuildSetting{
+			{Key: "-compiler", Value: "gc"},
+			{Key: "vcs.revision", Value: "6d1f5e4"},
+			{Key: "vcs.time", Value: "2019-10-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	})
+
+	assert.Equal(t, &BuildInfoS{
+		Path:         "example.com/app",
+		Revision:     "6d1f5e4",
+		RevisionTime: "2019-10-01T12:00:00Z",
+		Modified:     true,
+	}, info)
+}
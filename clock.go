@@ -0,0 +1,64 @@
+package instana
+
+import "time"
+
+// Clock is the source of time used by the tracer to timestamp spans and by the sensor to schedule
+// agent announcement retries and metrics collection. A custom Clock can be provided via Options.Clock
+// to make tests independent of the wall clock.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a Timer that fires once after d
+	NewTimer(d time.Duration) Timer
+	// NewTicker creates a Ticker that fires every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single event timer created by Clock
+type Timer interface {
+	// C returns the channel the current time is delivered to when the timer fires
+	C() <-chan time.Time
+	// Stop prevents the timer from firing
+	Stop() bool
+}
+
+// Ticker delivers ticks at intervals
+type Ticker interface {
+	// C returns the channel the ticks are delivered to
+	C() <-chan time.Time
+	// Stop turns off the ticker
+	Stop()
+}
+
+// SystemClock is the Clock backed by the time package, used by default
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
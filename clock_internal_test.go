@@ -0,0 +1,122 @@
+package instana
+
+import (
+	"sync"
+	"time"
+)
+
+// manualClock is a Clock that only moves forward when advanced explicitly
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+func newManualClock(now time.Time) *manualClock {
+	return &manualClock{now: now}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *manualClock) NewTimer(d time.Duration) Timer {
+	return c.schedule(d, 0)
+}
+
+func (c *manualClock) NewTicker(d time.Duration) Ticker {
+	return manualTicker{c.schedule(d, d)}
+}
+
+func (c *manualClock) schedule(d, period time.Duration) *manualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{
+		clock:  c,
+		c:      make(chan time.Time, 1),
+		at:     c.now.Add(d),
+		period: period,
+	}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Pending returns the number of timers and tickers that have not fired or been stopped yet
+func (c *manualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// Advance moves the clock forward by d firing all timers and tickers that are due
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var pending []*manualTimer
+	for _, t := range c.timers {
+		if !t.at.After(c.now) {
+			select {
+			case t.c <- c.now:
+			default:
+			}
+
+			if t.period == 0 {
+				continue
+			}
+
+			for !t.at.After(c.now) {
+				t.at = t.at.Add(t.period)
+			}
+		}
+
+		pending = append(pending, t)
+	}
+
+	c.timers = pending
+}
+
+func (c *manualClock) remove(t *manualTimer) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, tt := range c.timers {
+		if tt == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+type manualTimer struct {
+	clock  *manualClock
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() bool {
+	return t.clock.remove(t)
+}
+
+type manualTicker struct {
+	*manualTimer
+}
+
+func (t manualTicker) Stop() {
+	t.manualTimer.Stop()
+}
@@ -0,0 +1,71 @@
+package instana
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ContainerS struct to hold information about the container the process is running in.
+type ContainerS struct {
+	ID            string `json:"id,omitempty"`
+	CgroupVersion int    `json:"cgroup_version,omitempty"`
+	CgroupPath    string `json:"cgroup_path,omitempty"`
+}
+
+var containerIDRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// getContainerInfo parses the cgroup membership file (usually /proc/self/cgroup) and
+// returns the container ID along with the cgroup layout. It returns nil if the file
+// is not available.
+//
+// Each line of this file has the following format:
+//
+//	hierarchy-ID:controller-list:cgroup-path
+//
+// With cgroup v1 there is a line per controller, while with cgroup v2 there is only
+// one line with hierarchy ID 0 and an empty controller list. Container runtimes such as
+// Docker, containerd or CRI-O put the 64 characters long container ID into the cgroup path,
+// i.e. /docker/<id>, /kubepods/burstable/pod<uid>/<id> or /system.slice/docker-<id>.scope
+func getContainerInfo(cgroupFile string) *ContainerS {
+	f, err := os.Open(cgroupFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	ret := &ContainerS{}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		entry := strings.SplitN(s.Text(), ":", 3)
+		if len(entry) < 3 {
+			continue
+		}
+
+		// systems running in hybrid mode list both v1 controllers and the v2 unified
+		// hierarchy, in this case the v1 layout takes precedence
+		if entry[0] == "0" && entry[1] == "" {
+			if ret.CgroupVersion == 0 {
+				ret.CgroupVersion = 2
+				ret.CgroupPath = entry[2]
+			}
+		} else {
+			if ret.CgroupVersion != 1 || entry[1] == "memory" {
+				ret.CgroupPath = entry[2]
+			}
+			ret.CgroupVersion = 1
+		}
+
+		if ret.ID == "" {
+			ret.ID = containerIDRegexp.FindString(entry[2])
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		log.debug("failed to read", cgroupFile, err)
+	}
+
+	return ret
+}
@@ -0,0 +1,74 @@
+package instana
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContainerInfo(t *testing.T) {
+	tests := map[string]struct {
+		in       string
+		expected *ContainerS
+	}{
+		"docker cgroup v1": {
+			in: `12:memory:/docker/3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab
+11:cpu,cpuacct:/docker/3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab
+1:name=systemd:/docker/3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab
+`,
+			expected: &ContainerS{
+				ID:            "3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab",
+				CgroupVersion: 1,
+				CgroupPath:    "/docker/3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab",
+			},
+		},
+		"kubernetes cgroup v1": {
+			in: `11:cpuset:/kubepods/burstable/pod5c7a3c4e-1f2b-4a3c-9d8e-7f6a5b4c3d2e/0a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcdef
+10:memory:/kubepods/burstable/pod5c7a3c4e-1f2b-4a3c-9d8e-7f6a5b4c3d2e/0a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcdef
+0::/
+`,
+			expected: &ContainerS{
+				ID:            "0a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcdef",
+				CgroupVersion: 1,
+				CgroupPath:    "/kubepods/burstable/pod5c7a3c4e-1f2b-4a3c-9d8e-7f6a5b4c3d2e/0a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcdef",
+			},
+		},
+		"docker cgroup v2": {
+			in: "0::/system.slice/docker-3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab.scope\n",
+			expected: &ContainerS{
+				ID:            "3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab",
+				CgroupVersion: 2,
+				CgroupPath:    "/system.slice/docker-3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab.scope",
+			},
+		},
+		"host cgroup v2": {
+			in: "0::/user.slice/user-1000.slice/session-2.scope\n",
+			expected: &ContainerS{
+				CgroupVersion: 2,
+				CgroupPath:    "/user.slice/user-1000.slice/session-2.scope",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpFile, err := ioutil.TempFile("", "getcontainerinfo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(tmpFile.Name())
+
+			if _, err := tmpFile.WriteString(test.in); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, test.expected, getContainerInfo(tmpFile.Name()))
+		})
+	}
+}
+
+func TestGetContainerInfoMissingFile(t *testing.T) {
+	assert.Nil(t, getContainerInfo("/nonexistent/cgroup"))
+}
@@ -0,0 +1,390 @@
+package instana
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxCustomMetrics is the default maximum number of distinct custom metric
+// series (name and tags combinations) reported by the sensor.
+const DefaultMaxCustomMetrics = 500
+
+// Custom metric types as reported to the agent
+const (
+	CounterMetricType   = "counter"
+	GaugeMetricType     = "gauge"
+	HistogramMetricType = "histogram"
+)
+
+// CustomMetricS struct to hold the value of a user-defined metric collected
+// during the last interval.
+type CustomMetricS struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Value   float64           `json:"value"`
+	Count   uint64            `json:"count,omitempty"`
+	Buckets []BucketS         `json:"buckets,omitempty"`
+}
+
+// BucketS struct to hold the number of histogram observations less than or
+// equal to the upper bound. The last bucket of a histogram has an infinite upper
+// bound and counts all observations, which is reported as "+Inf".
+type BucketS struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// MarshalJSON serializes the bucket, reporting an infinite upper bound as "+Inf"
+func (b BucketS) MarshalJSON() ([]byte, error) {
+	if !math.IsInf(b.UpperBound, 1) {
+		type bucket BucketS
+		return json.Marshal(bucket(b))
+	}
+
+	return json.Marshal(struct {
+		UpperBound string `json:"le"`
+		Count      uint64 `json:"count"`
+	}{"+Inf", b.Count})
+}
+
+type customMetric interface {
+	metricType() string
+	collect(m *CustomMetricS)
+}
+
+// Counter is a monotonically increasing custom metric, such as the number of
+// processed jobs. The sensor reports the increase since the previous collection.
+type Counter struct {
+	value    int64
+	reported int64
+}
+
+// Inc increments the counter by 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by delta. Negative values are ignored.
+func (c *Counter) Add(delta int64) {
+	if delta < 0 {
+		return
+	}
+
+	atomic.AddInt64(&c.value, delta)
+}
+
+// Value returns the total value of the counter
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+func (c *Counter) metricType() string {
+	return CounterMetricType
+}
+
+func (c *Counter) collect(m *CustomMetricS) {
+	v := c.Value()
+	m.Value = float64(v - atomic.SwapInt64(&c.reported, v))
+}
+
+// Gauge is a custom metric that represents a single value that can go up and down,
+// such as the queue depth or the cache hit rate.
+type Gauge struct {
+	bits uint64
+}
+
+// Set sets the gauge value. NaN and infinite values are ignored.
+func (g *Gauge) Set(v float64) {
+	if !isFinite(v) {
+		return
+	}
+
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add adds delta to the gauge value. Deltas that are NaN or would make the value
+// infinite are ignored.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+
+		v := math.Float64frombits(old) + delta
+		if !isFinite(v) {
+			return
+		}
+
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(v)) {
+			return
+		}
+	}
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) metricType() string {
+	return GaugeMetricType
+}
+
+func (g *Gauge) collect(m *CustomMetricS) {
+	m.Value = g.Value()
+}
+
+// DefaultHistogramBuckets are the upper bounds used for histograms created without
+// explicit buckets. They are suited to measure durations in milliseconds.
+var DefaultHistogramBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Histogram is a custom metric that samples observations, such as request durations,
+// and counts them in configurable buckets. The sensor reports the count, the sum and
+// the bucket counts of observations made since the previous collection.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultHistogramBuckets
+	}
+
+	sorted := make([]float64, len(bounds))
+	copy(sorted, bounds)
+	sort.Float64s(sorted)
+
+	// the extra bucket counts observations greater than the largest bound
+	return &Histogram{
+		bounds:  sorted,
+		buckets: make([]uint64, len(sorted)+1),
+	}
+}
+
+// Observe adds a single observation to the histogram. NaN and infinite values are ignored.
+func (h *Histogram) Observe(v float64) {
+	if !isFinite(v) {
+		return
+	}
+
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.count++
+	h.sum += v
+	h.buckets[i]++
+}
+
+func (h *Histogram) metricType() string {
+	return HistogramMetricType
+}
+
+func (h *Histogram) collect(m *CustomMetricS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	m.Value = h.sum
+	m.Count = h.count
+	m.Buckets = make([]BucketS, len(h.buckets))
+
+	var cumulative uint64
+	for i := range h.buckets {
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+
+		cumulative += h.buckets[i]
+		m.Buckets[i] = BucketS{UpperBound: bound, Count: cumulative}
+		h.buckets[i] = 0
+	}
+
+	h.count, h.sum = 0, 0
+}
+
+type customMetricsRegistry struct {
+	mu       sync.Mutex
+	limit    int
+	metrics  map[string]customMetric
+	names    map[string]string
+	tags     map[string]map[string]string
+	warnOnce sync.Once
+}
+
+var customMetrics = newCustomMetricsRegistry(DefaultMaxCustomMetrics)
+
+func newCustomMetricsRegistry(limit int) *customMetricsRegistry {
+	return &customMetricsRegistry{
+		limit:   limit,
+		metrics: make(map[string]customMetric),
+		names:   make(map[string]string),
+		tags:    make(map[string]map[string]string),
+	}
+}
+
+func (r *customMetricsRegistry) setLimit(limit int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limit = limit
+}
+
+// GetCounter returns the counter registered with given name and tags, creating it
+// on first use. Counters exceeding the Options.MaxCustomMetrics limit or already
+// registered as another metric type are not reported.
+func GetCounter(name string, tags map[string]string) *Counter {
+	m := customMetrics.register(name, tags, func() customMetric { return &Counter{} })
+
+	c, ok := m.(*Counter)
+	if !ok {
+		warnMetricTypeMismatch(name, m, CounterMetricType)
+		return &Counter{}
+	}
+
+	return c
+}
+
+// GetGauge returns the gauge registered with given name and tags, creating it
+// on first use. Gauges exceeding the Options.MaxCustomMetrics limit or already
+// registered as another metric type are not reported.
+func GetGauge(name string, tags map[string]string) *Gauge {
+	m := customMetrics.register(name, tags, func() customMetric { return &Gauge{} })
+
+	g, ok := m.(*Gauge)
+	if !ok {
+		warnMetricTypeMismatch(name, m, GaugeMetricType)
+		return &Gauge{}
+	}
+
+	return g
+}
+
+// GetHistogram returns the histogram registered with given name and tags, creating
+// it with provided bucket upper bounds on first use. If no buckets are provided,
+// DefaultHistogramBuckets are used. Histograms exceeding the Options.MaxCustomMetrics
+// limit or already registered as another metric type are not reported.
+func GetHistogram(name string, tags map[string]string, buckets ...float64) *Histogram {
+	m := customMetrics.register(name, tags, func() customMetric { return newHistogram(buckets) })
+
+	h, ok := m.(*Histogram)
+	if !ok {
+		warnMetricTypeMismatch(name, m, HistogramMetricType)
+		return newHistogram(buckets)
+	}
+
+	return h
+}
+
+// warnMetricTypeMismatch logs that a metric requested as typ will not be reported, since
+// another metric type has already been registered under the same name and tags
+func warnMetricTypeMismatch(name string, registered customMetric, typ string) {
+	if registered == nil {
+		// the registry limit has been reached and has already been logged
+		return
+	}
+
+	if log == nil {
+		return
+	}
+
+	log.warn("custom metric", name, "is already registered as", registered.metricType(), "and will not be reported as", typ)
+}
+
+// register returns the metric stored under the name and tags combination or adds a new one.
+// It returns nil if the registry is full.
+func (r *customMetricsRegistry) register(name string, tags map[string]string, newMetric func() customMetric) customMetric {
+	key := customMetricKey(name, tags)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[key]; ok {
+		return m
+	}
+
+	if r.limit > 0 && len(r.metrics) >= r.limit {
+		r.warnOnce.Do(func() {
+			if log != nil {
+				log.warn("custom metrics limit of", r.limit, "series reached, new metrics will not be reported")
+			}
+		})
+
+		return nil
+	}
+
+	m := newMetric()
+	r.metrics[key] = m
+	r.names[key] = name
+
+	if len(tags) > 0 {
+		t := make(map[string]string, len(tags))
+		for k, v := range tags {
+			t[k] = v
+		}
+		r.tags[key] = t
+	}
+
+	return m
+}
+
+// collect returns the values of all registered metrics sorted by name and tags
+func (r *customMetricsRegistry) collect() []CustomMetricS {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.metrics) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(r.metrics))
+	for k := range r.metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]CustomMetricS, len(keys))
+	for i, k := range keys {
+		m := r.metrics[k]
+		ret[i] = CustomMetricS{
+			Name: r.names[k],
+			Type: m.metricType(),
+			Tags: r.tags[k],
+		}
+		m.collect(&ret[i])
+	}
+
+	return ret
+}
+
+// customMetricKeyEscaper escapes the characters used as separators in custom metric keys,
+// so that names and tags containing them do not collide
+var customMetricKeyEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, `{`, `\{`, `}`, `\}`)
+
+func customMetricKey(name string, tags map[string]string) string {
+	name = customMetricKeyEscaper.Replace(name)
+	if len(tags) == 0 {
+		return name
+	}
+
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, customMetricKeyEscaper.Replace(k)+"="+customMetricKeyEscaper.Replace(v))
+	}
+	sort.Strings(pairs)
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// isFinite returns whether v can be reported, i.e. is neither NaN nor infinite
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
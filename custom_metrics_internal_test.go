@@ -0,0 +1,164 @@
+package instana
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomMetricsRegistry(t *testing.T) {
+	r := newCustomMetricsRegistry(DefaultMaxCustomMetrics)
+
+	c := r.register("jobs", map[string]string{"queue": "mail"}, func() customMetric { return &Counter{} }).(*Counter)
+	g := r.register("queue.depth", nil, func() customMetric { return &Gauge{} }).(*Gauge)
+	h := r.register("latency", nil, func() customMetric { return newHistogram([]float64{10, 100}) }).(*Histogram)
+
+	// Same name and tags return the same metric regardless of tags order
+	assert.Equal(t, c, r.register("jobs", map[string]string{"queue": "mail"}, func() customMetric { return &Counter{} }))
+
+	c.Add(3)
+	c.Inc()
+	c.Add(-10)
+	g.Set(42)
+	g.Add(-2)
+	h.Observe(5)
+	h.Observe(50)
+	h.Observe(500)
+
+	metrics := r.collect()
+	require.Len(t, metrics, 3)
+
+	assert.Equal(t, CustomMetricS{
+		Name:  "jobs",
+		Type:  CounterMetricType,
+		Tags:  map[string]string{"queue": "mail"},
+		Value: 4,
+	}, metrics[0])
+
+	assert.Equal(t, CustomMetricS{
+		Name:  "latency",
+		Type:  HistogramMetricType,
+		Value: 555,
+		Count: 3,
+		Buckets: []BucketS{
+			{UpperBound: 10, Count: 1},
+			{UpperBound: 100, Count: 2},
+			{UpperBound: math.Inf(1), Count: 3},
+		},
+	}, metrics[1])
+
+	assert.Equal(t, CustomMetricS{
+		Name:  "queue.depth",
+		Type:  GaugeMetricType,
+		Value: 40,
+	}, metrics[2])
+
+	// Counters and histograms report values since the previous collection
+	c.Inc()
+	metrics = r.collect()
+	assert.Equal(t, float64(1), metrics[0].Value)
+	assert.Equal(t, uint64(0), metrics[1].Count)
+	assert.Equal(t, float64(40), metrics[2].Value)
+	assert.Equal(t, int64(5), c.Value())
+}
+
+func TestCustomMetricsRegistryLimit(t *testing.T) {
+	r := newCustomMetricsRegistry(2)
+
+	assert.NotNil(t, r.register("a", nil, func() customMetric { return &Counter{} }))
+	assert.NotNil(t, r.register("b", nil, func() customMetric { return &Counter{} }))
+	assert.Nil(t, r.register("c", nil, func() customMetric { return &Counter{} }))
+
+	// Existing series are still returned
+	assert.NotNil(t, r.register("a", nil, func() customMetric { return &Counter{} }))
+	assert.Len(t, r.collect(), 2)
+}
+
+func TestGetCounterTypeMismatch(t *testing.T) {
+	g := GetGauge("test.type.mismatch", nil)
+	c := GetCounter("test.type.mismatch", nil)
+
+	require.NotNil(t, c)
+	c.Inc()
+	g.Set(1)
+
+	assert.Equal(t, int64(1), c.Value())
+}
+
+func TestCounterConcurrentUpdates(t *testing.T) {
+	r := newCustomMetricsRegistry(DefaultMaxCustomMetrics)
+	tags := map[string]string{"k": "v"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.register("test.concurrent", tags, func() customMetric { return &Counter{} }).(*Counter).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+
+	metrics := r.collect()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, float64(10000), metrics[0].Value)
+}
+
+func TestBucketS_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal([]BucketS{
+		{UpperBound: 2.5, Count: 1},
+		{UpperBound: math.Inf(1), Count: 3},
+	})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `[{"le": 2.5, "count": 1}, {"le": "+Inf", "count": 3}]`, string(data))
+}
+
+func TestCustomMetrics_NonFiniteValues(t *testing.T) {
+	r := newCustomMetricsRegistry(DefaultMaxCustomMetrics)
+
+	g := r.register("queue.depth", nil, func() customMetric { return &Gauge{} }).(*Gauge)
+	h := r.register("latency", nil, func() customMetric { return newHistogram([]float64{10}) }).(*Histogram)
+
+	g.Set(5)
+	g.Set(math.NaN())
+	g.Add(math.Inf(1))
+	assert.Equal(t, float64(5), g.Value())
+
+	h.Observe(1)
+	h.Observe(math.NaN())
+	h.Observe(math.Inf(-1))
+
+	metrics := r.collect()
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, float64(1), metrics[0].Value)
+	assert.Equal(t, uint64(1), metrics[0].Count)
+
+	// custom metrics are sent along with the runtime ones, so they must not break the payload
+	_, err := json.Marshal(EntityData{Custom: metrics})
+	require.NoError(t, err)
+}
+
+func TestCustomMetricKey_Escaping(t *testing.T) {
+	assert.NotEqual(t,
+		customMetricKey("jobs", map[string]string{"queue": "mail,priority=high"}),
+		customMetricKey("jobs", map[string]string{"queue": "mail", "priority": "high"}),
+	)
+
+	assert.NotEqual(t,
+		customMetricKey("jobs{queue=mail}", nil),
+		customMetricKey("jobs", map[string]string{"queue": "mail"}),
+	)
+
+	assert.NotEqual(t,
+		customMetricKey("jobs", map[string]string{`a\`: "b"}),
+		customMetricKey("jobs", map[string]string{`a`: `\b`}),
+	)
+}
@@ -2,36 +2,147 @@ package instana
 
 import (
 	"bytes"
-	"io/ioutil"
-	"strings"
+	"context"
+	"html/template"
+	"io"
+	"net/http"
+
+	ot "github.com/opentracing/opentracing-go"
+)
+
+// Defaults for the EUM snippet
+const (
+	DefaultEumReportingURL = "https://eum-saas.instana.io"
+	DefaultEumScriptURL    = "//eum.instana.io/eum.min.js"
 )
 
-const eumTemplate string = "eum.js"
+// eumTemplate is the JavaScript agent initialization snippet. It is rendered with html/template,
+// so that all values are escaped according to the JavaScript string context they are put into.
+const eumTemplate = `<script>
+(function(c,e,f,k,g,h,b,a,d){c[g]||(c[g]=h,b=c[h]=function(){
+  b.q.push(arguments)},b.q=[],b.l=1*new Date,a=e.createElement(f),a.async=1,
+    a.src=k,a.setAttribute("crossorigin", "anonymous"),d=e.getElementsByTagName(f)[0],
+    d.parentNode.insertBefore(a,d))})(window,document,"script",
+    "{{.ScriptURL}}","InstanaEumObject","ineum");
+  ineum('reportingUrl', '{{.ReportingURL}}');
+  ineum('key', '{{.APIKey}}');
+  ineum('traceId', '{{.TraceID}}');
+{{- range $key, $value := .Meta}}
+  ineum('meta', '{{$key}}', '{{$value}}');
+{{- end}}
+</script>`
+
+var eumSnippetTemplate = template.Must(template.New("eum").Parse(eumTemplate))
+
+// EumOptions configures the EUM snippet
+type EumOptions struct {
+	// ReportingURL is the URL the browser sends EUM data to, defaults to DefaultEumReportingURL
+	ReportingURL string
+	// ScriptURL is the location of the JavaScript agent, defaults to DefaultEumScriptURL
+	ScriptURL string
+}
+
+type eumSnippetData struct {
+	ScriptURL    string
+	ReportingURL string
+	APIKey       string
+	TraceID      string
+	Meta         map[string]string
+}
 
 // EumSnippet generates javascript code to initialize JavaScript agent
 func EumSnippet(apiKey string, traceID string, meta map[string]string) string {
+	return EumSnippetWithOptions(apiKey, traceID, meta, EumOptions{})
+}
+
+// EumSnippetWithOptions generates javascript code to initialize JavaScript agent reporting to a custom location
+func EumSnippetWithOptions(apiKey string, traceID string, meta map[string]string, opts EumOptions) string {
+	return string(renderEumSnippet(apiKey, traceID, meta, opts))
+}
+
+// EumSnippetHTML generates javascript code to initialize JavaScript agent for the trace of the active span
+// found in ctx, i.e. the request context passed to a handler wrapped with Sensor.TracingHandler(). The result
+// can be used in html/template templates as is. An empty snippet is returned if there is no active span.
+func EumSnippetHTML(ctx context.Context, apiKey string, meta map[string]string, opts EumOptions) template.HTML {
+	traceID, ok := traceIDFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return renderEumSnippet(apiKey, traceID, meta, opts)
+}
 
+// EumSnippetHandler returns an http.Handler that responds with the HTML fragment initializing JavaScript
+// agent for the trace of the request, e.g. to be included into pages rendered by a client-side framework.
+// The handler needs to be wrapped with Sensor.TracingHandler() to have an active span in the request
+// context, otherwise it responds with 204 No Content.
+func EumSnippetHandler(apiKey string, meta map[string]string, opts EumOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		snippet := EumSnippetHTML(req.Context(), apiKey, meta, opts)
+		if snippet == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, string(snippet))
+	})
+}
+
+func renderEumSnippet(apiKey string, traceID string, meta map[string]string, opts EumOptions) template.HTML {
 	if len(apiKey) == 0 || len(traceID) == 0 {
 		return ""
 	}
 
-	b, err := ioutil.ReadFile(eumTemplate)
+	data := eumSnippetData{
+		ScriptURL:    opts.ScriptURL,
+		ReportingURL: opts.ReportingURL,
+		APIKey:       apiKey,
+		TraceID:      traceID,
+		Meta:         meta,
+	}
+
+	if data.ScriptURL == "" {
+		data.ScriptURL = DefaultEumScriptURL
+	}
 
-	if err != nil {
+	if data.ReportingURL == "" {
+		data.ReportingURL = DefaultEumReportingURL
+	}
+
+	var buf bytes.Buffer
+	if err := eumSnippetTemplate.Execute(&buf, data); err != nil {
+		log.info("failed to render EUM snippet:", err)
 		return ""
 	}
 
-	var snippet = string(b)
-	var metaBuffer bytes.Buffer
+	// the output has been escaped by html/template
+	return template.HTML(buf.String())
+}
 
-	snippet = strings.Replace(snippet, "$apiKey", apiKey, -1)
-	snippet = strings.Replace(snippet, "$traceId", traceID, -1)
+// traceIDFromContext returns the trace ID of the active span in ctx formatted to be used with EUM
+func traceIDFromContext(ctx context.Context) (string, bool) {
+	span := ot.SpanFromContext(ctx)
+	if span == nil {
+		if sp, ok := ctx.Value("parentSpan").(ot.Span); ok {
+			span = sp
+		}
+	}
 
-	for key, value := range meta {
-		metaBuffer.WriteString("  ineum('meta', '" + key + "', '" + value + "');\n")
+	if span == nil {
+		return "", false
 	}
 
-	snippet = strings.Replace(snippet, "$meta", metaBuffer.String(), -1)
+	sc, ok := span.Context().(SpanContext)
+	if !ok {
+		return "", false
+	}
+
+	traceID, err := ID2Header(sc.TraceID)
+	if err != nil {
+		return "", false
+	}
 
-	return snippet
+	return traceID, true
 }
@@ -0,0 +1,266 @@
+package instana
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// eumMaxHeadSize is the maximum number of bytes buffered while looking for the closing </head> tag.
+// Responses with a larger <head> section are sent as is.
+const eumMaxHeadSize = 64 * 1024
+
+var eumHeadCloseTag = []byte("</head>")
+
+// EumHandler wraps an existing http.HandlerFunc to inject the EUM snippet into its HTML responses.
+// The snippet is initialized with the trace ID of the active span, so the handler is expected to be
+// wrapped with TracingHandler:
+//
+//	http.HandleFunc("/", sensor.TracingHandler("index", sensor.EumHandler("myEumApiKey", nil, instana.EumOptions{}, index)))
+//
+// The response is buffered until the closing </head> tag is written, and the snippet is inserted right
+// before it. Responses that are not text/html, are already compressed or have no <head> section within
+// the first 64KB are left untouched.
+func (s *Sensor) EumHandler(apiKey string, meta map[string]string, opts EumOptions, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		snippet := EumSnippetHTML(req.Context(), apiKey, meta, opts)
+		if snippet == "" {
+			handler(w, req)
+			return
+		}
+
+		iw := &eumInjectingWriter{
+			w:       w,
+			snippet: []byte(snippet),
+			status:  http.StatusOK,
+		}
+		defer iw.finish()
+
+		handler(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return iw.WriteHeader
+			},
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return iw.Write
+			},
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					return io.Copy(writerFunc(iw.Write), src)
+				}
+			},
+			Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					iw.flush()
+					next()
+				}
+			},
+		}), req)
+	}
+}
+
+type eumWriterState int
+
+const (
+	eumUndecided eumWriterState = iota
+	eumBuffering
+	eumPassthrough
+)
+
+// eumInjectingWriter buffers the beginning of an HTML response until the closing </head> tag
+// and inserts the EUM snippet before it. Once the snippet is injected, or it is clear that
+// it can't be, the rest of the response is written through.
+type eumInjectingWriter struct {
+	w       http.ResponseWriter
+	snippet []byte
+
+	state       eumWriterState
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (iw *eumInjectingWriter) WriteHeader(code int) {
+	if iw.wroteHeader || iw.state != eumUndecided {
+		return
+	}
+
+	iw.status = code
+
+	// content type may not be known yet, in this case the decision is made on the first write
+	if iw.w.Header().Get("Content-Type") != "" || !bodyAllowed(code) {
+		iw.decide(nil)
+	}
+}
+
+func (iw *eumInjectingWriter) Write(p []byte) (int, error) {
+	if iw.state == eumUndecided {
+		iw.decide(p)
+	}
+
+	if iw.state == eumPassthrough {
+		iw.writeHeader()
+		return iw.w.Write(p)
+	}
+
+	// look for the closing tag only in the new data, allowing it to span across writes
+	start := iw.buf.Len() - len(eumHeadCloseTag) + 1
+	if start < 0 {
+		start = 0
+	}
+
+	iw.buf.Write(p)
+
+	if i := indexFoldASCII(iw.buf.Bytes()[start:], eumHeadCloseTag); i >= 0 {
+		if err := iw.inject(start + i); err != nil {
+			return 0, err
+		}
+
+		return len(p), nil
+	}
+
+	if iw.buf.Len() > eumMaxHeadSize {
+		log.debug("no </head> tag found in the first", eumMaxHeadSize, "bytes, skipping EUM snippet injection")
+
+		if err := iw.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// decide checks whether the snippet can be injected into the response judging by its headers
+func (iw *eumInjectingWriter) decide(firstChunk []byte) {
+	h := iw.w.Header()
+
+	if h.Get("Content-Type") == "" && firstChunk != nil {
+		h.Set("Content-Type", http.DetectContentType(firstChunk))
+	}
+
+	iw.state = eumPassthrough
+	if !bodyAllowed(iw.status) {
+		return
+	}
+
+	if enc := h.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err != nil || mediaType != "text/html" {
+		return
+	}
+
+	iw.state = eumBuffering
+}
+
+// inject writes the buffered data with the snippet inserted at pos
+func (iw *eumInjectingWriter) inject(pos int) error {
+	h := iw.w.Header()
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil {
+			h.Set("Content-Length", strconv.Itoa(n+len(iw.snippet)))
+		}
+	}
+
+	iw.state = eumPassthrough
+	iw.writeHeader()
+
+	data := iw.buf.Bytes()
+	defer iw.buf.Reset()
+
+	for _, chunk := range [][]byte{data[:pos], iw.snippet, data[pos:]} {
+		if _, err := iw.w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushBuffer gives up on injecting the snippet and writes the buffered data as is
+func (iw *eumInjectingWriter) flushBuffer() error {
+	iw.state = eumPassthrough
+	iw.writeHeader()
+
+	defer iw.buf.Reset()
+	if iw.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := iw.w.Write(iw.buf.Bytes())
+
+	return err
+}
+
+// flush is called when the handler flushes the response, which means that the data written so far
+// should be delivered to the client without waiting for the closing </head> tag
+func (iw *eumInjectingWriter) flush() {
+	if iw.state == eumUndecided {
+		iw.decide(nil)
+	}
+
+	if iw.state == eumBuffering {
+		iw.flushBuffer()
+	}
+
+	iw.writeHeader()
+}
+
+// finish writes the response remaining in buffer once the handler returns
+func (iw *eumInjectingWriter) finish() {
+	if iw.state == eumBuffering {
+		iw.flushBuffer()
+	}
+
+	// the handler might not have written anything except the status code
+	iw.writeHeader()
+}
+
+func (iw *eumInjectingWriter) writeHeader() {
+	if iw.wroteHeader {
+		return
+	}
+
+	iw.wroteHeader = true
+	iw.w.WriteHeader(iw.status)
+}
+
+// bodyAllowed reports whether a given response status code permits a body, see RFC 7230, section 3.3
+func bodyAllowed(status int) bool {
+	return (status < 100 || status > 199) && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// indexFoldASCII returns the index of the first occurrence of lowercase ASCII sep in s ignoring the
+// case of ASCII letters, or -1 if there is none. Unlike bytes.ToLower, it does not change the length
+// of s if it contains non-ASCII or invalid UTF-8 characters, and does not allocate.
+func indexFoldASCII(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		j := 0
+		for ; j < len(sep); j++ {
+			c := s[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+
+			if c != sep[j] {
+				break
+			}
+		}
+
+		if j == len(sep) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
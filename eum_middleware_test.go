@@ -0,0 +1,157 @@
+package instana_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	instana "github.com/instana/go-sensor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eumTestPage = `<!DOCTYPE html><html><head><title>Test</title></head><body>Hello</body></html>`
+
+func serveEum(t *testing.T, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	s := instana.NewSensor("eum-test")
+
+	rec := httptest.NewRecorder()
+	s.TracingHandler("eum", s.EumHandler("myApiKey", map[string]string{"user": "alice"}, instana.EumOptions{}, handler))(
+		rec,
+		httptest.NewRequest(http.MethodGet, "/", nil),
+	)
+
+	return rec
+}
+
+func TestEumHandler_InjectsSnippet(t *testing.T) {
+	rec := serveEum(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(eumTestPage)))
+		w.Write([]byte(eumTestPage))
+	})
+
+	body := rec.Body.String()
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, strconv.Itoa(len(body)), rec.Header().Get("Content-Length"))
+
+	require.True(t, strings.HasPrefix(body, "<!DOCTYPE html><html><head><title>Test</title><script>"), body)
+	assert.True(t, strings.HasSuffix(body, "</script></head><body>Hello</body></html>"), body)
+	assert.Contains(t, body, "ineum('key', 'myApiKey');")
+	assert.Contains(t, body, "ineum('meta', 'user', 'alice');")
+	assert.NotContains(t, body, "ineum('traceId', '');")
+}
+
+func TestEumHandler_SplitWrites(t *testing.T) {
+	rec := serveEum(t, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("<html><HEAD><title>Test</title></HE"))
+		w.Write([]byte("AD><body>"))
+		w.Write([]byte("Hello</body></html>"))
+	})
+
+	body := rec.Body.String()
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(body, "<html><HEAD><title>Test</title><script>"), body)
+	assert.True(t, strings.HasSuffix(body, "</script></HEAD><body>Hello</body></html>"), body)
+}
+
+func TestEumHandler_NonUTF8Page(t *testing.T) {
+	// "café" in ISO-8859-1 is not valid UTF-8, and İ is longer once lowercased
+	page := "<html><head><title>caf\xe9 \u0130</title></head><body>caf\xe9</body></html>"
+
+	rec := serveEum(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte(page))
+	})
+
+	body := rec.Body.String()
+
+	require.True(t, strings.HasPrefix(body, "<html><head><title>caf\xe9 \u0130</title><script>"), body)
+	assert.True(t, strings.HasSuffix(body, "</script></head><body>caf\xe9</body></html>"), body)
+}
+
+func TestEumHandler_NoHead(t *testing.T) {
+	rec := serveEum(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>Hello</p>"))
+	})
+
+	assert.Equal(t, "<p>Hello</p>", rec.Body.String())
+}
+
+func TestEumHandler_FlushBeforeHead(t *testing.T) {
+	rec := serveEum(t, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head>"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("</head><body></body></html>"))
+	})
+
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "<html><head></head><body></body></html>", rec.Body.String())
+}
+
+func TestEumHandler_LeavesOtherResponsesUntouched(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(eumTestPage))
+	gz.Close()
+
+	tests := map[string]struct {
+		handler  http.HandlerFunc
+		expected []byte
+	}{
+		"json": {
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"head":"</head>"}`))
+			},
+			expected: []byte(`{"head":"</head>"}`),
+		},
+		"gzip": {
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(gzipped.Bytes())
+			},
+			expected: gzipped.Bytes(),
+		},
+		"not modified": {
+			handler: func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			},
+			expected: []byte{},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := serveEum(t, test.handler)
+
+			body, err := ioutil.ReadAll(rec.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, body)
+		})
+	}
+}
+
+func TestEumHandler_NoActiveSpan(t *testing.T) {
+	s := instana.NewSensor("eum-test")
+
+	rec := httptest.NewRecorder()
+	s.EumHandler("myApiKey", nil, instana.EumOptions{}, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(eumTestPage))
+	})(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, eumTestPage, rec.Body.String())
+}
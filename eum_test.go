@@ -1,10 +1,15 @@
 package instana_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	instana "github.com/instana/go-sensor"
+	ot "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const eumExpectedResult string = `<script>
@@ -33,3 +38,80 @@ func TestEum(t *testing.T) {
 	assert.Contains(t, result, "ineum('meta', 'key1', 'value1');")
 	assert.Contains(t, result, "ineum('meta', 'key2', 'value2');")
 }
+
+func TestEumSnippetEscaping(t *testing.T) {
+	result := instana.EumSnippet("myApiKey", "myTraceId", map[string]string{
+		"user": "'); alert(document.cookie); ('",
+		"tag":  "</script><script>alert(1)</script>",
+	})
+
+	assert.NotContains(t, result, "alert(document.cookie); ('")
+	assert.NotContains(t, result, "</script><script>")
+	assert.Regexp(t, `ineum\('meta', 'user', '\\(x27|u0027)\); alert\(document.cookie\); \(\\(x27|u0027)'\);`, result)
+}
+
+func TestEumSnippetWithOptions(t *testing.T) {
+	result := instana.EumSnippetWithOptions("myApiKey", "myTraceId", nil, instana.EumOptions{
+		ReportingURL: "https://eum.example.com",
+		ScriptURL:    "https://cdn.example.com/eum.min.js",
+	})
+
+	assert.Contains(t, result, `"https:\/\/cdn.example.com\/eum.min.js"`)
+	assert.Contains(t, result, `ineum('reportingUrl', 'https:\/\/eum.example.com');`)
+	assert.NotContains(t, result, "ineum('meta'")
+}
+
+func TestEumSnippetMissingData(t *testing.T) {
+	assert.Empty(t, instana.EumSnippet("", "myTraceId", nil))
+	assert.Empty(t, instana.EumSnippet("myApiKey", "", nil))
+}
+
+func TestEumSnippetHTML(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	sp := tracer.StartSpan("test")
+	defer sp.Finish()
+
+	traceID, err := instana.ID2Header(sp.Context().(instana.SpanContext).TraceID)
+	require.NoError(t, err)
+
+	t.Run("opentracing context", func(t *testing.T) {
+		result := instana.EumSnippetHTML(ot.ContextWithSpan(context.Background(), sp), "myApiKey", nil, instana.EumOptions{})
+		assert.Contains(t, string(result), "ineum('traceId', '"+traceID+"');")
+	})
+
+	t.Run("tracing handler context", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), "parentSpan", sp)
+
+		result := instana.EumSnippetHTML(ctx, "myApiKey", nil, instana.EumOptions{})
+		assert.Contains(t, string(result), "ineum('traceId', '"+traceID+"');")
+	})
+
+	t.Run("no active span", func(t *testing.T) {
+		assert.Empty(t, instana.EumSnippetHTML(context.Background(), "myApiKey", nil, instana.EumOptions{}))
+	})
+}
+
+func TestEumSnippetHandler(t *testing.T) {
+	s := instana.NewSensorWithTracer(instana.NewTracerWithEverything(&instana.Options{}, instana.NewTestRecorder()))
+	h := instana.EumSnippetHandler("myApiKey", map[string]string{"user": "42"}, instana.EumOptions{})
+
+	t.Run("traced request", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		s.TracingHandler("eum", h.ServeHTTP)(rec, httptest.NewRequest(http.MethodGet, "/eum", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Contains(t, rec.Body.String(), "ineum('traceId', '")
+		assert.Contains(t, rec.Body.String(), "ineum('meta', 'user', '42');")
+	})
+
+	t.Run("no active span", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/eum", nil))
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+}
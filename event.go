@@ -1,6 +1,10 @@
 package instana
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -10,20 +14,22 @@ type EventData struct {
 	Text  string `json:"text"`
 	// Duration in milliseconds
 	Duration int `json:"duration"`
-	// Severity with value of -1, 5, 10 : see type severity
-	Severity int    `json:"severity"`
-	Plugin   string `json:"plugin,omitempty"`
-	ID       string `json:"id,omitempty"`
-	Host     string `json:"host"`
+	// Severity with value of -1, 5, 10 : see type Severity
+	Severity int               `json:"severity"`
+	Plugin   string            `json:"plugin,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Host     string            `json:"host"`
+	Fields   map[string]string `json:"fields,omitempty"`
 }
 
-type severity int
+// Severity is the event severity
+type Severity int
 
 //Severity values for events sent to the instana agent
 const (
-	SeverityChange   severity = -1
-	SeverityWarning  severity = 5
-	SeverityCritical severity = 10
+	SeverityChange   Severity = -1
+	SeverityWarning  Severity = 5
+	SeverityCritical Severity = 10
 )
 
 // Defaults for the Event API
@@ -32,8 +38,104 @@ const (
 	ServiceHost   = ""
 )
 
+// Event delivery retry settings
+const (
+	eventMaxAttempts  = 3
+	eventRetryBackoff = 100 * time.Millisecond
+)
+
+// Event is a custom event reported to Instana. Events are immutable, all With* methods
+// return a modified copy, so that a partially configured event can be used as a template:
+//
+//	deployment := instana.NewEvent("Deployment").WithSeverity(instana.SeverityChange)
+//	err := instana.SendEvent(ctx, deployment.WithText("Released v1.2.3").WithField("version", "1.2.3"))
+type Event struct {
+	data EventData
+}
+
+// NewEvent returns a change event with given title
+func NewEvent(title string) Event {
+	return Event{
+		data: EventData{
+			Title:    title,
+			Severity: int(SeverityChange),
+		},
+	}
+}
+
+// WithText returns a copy of the event with the description text set
+func (e Event) WithText(text string) Event {
+	e.data.Text = text
+	return e
+}
+
+// WithSeverity returns a copy of the event with the severity set
+func (e Event) WithSeverity(sev Severity) Event {
+	e.data.Severity = int(sev)
+	return e
+}
+
+// WithDuration returns a copy of the event with the duration set
+func (e Event) WithDuration(d time.Duration) Event {
+	e.data.Duration = int(d / time.Millisecond)
+	return e
+}
+
+// WithPlugin returns a copy of the event with the plugin of the affected entity set,
+// i.e. ServicePlugin
+func (e Event) WithPlugin(plugin string) Event {
+	e.data.Plugin = plugin
+	return e
+}
+
+// WithEntityID returns a copy of the event with the ID of the affected entity set,
+// i.e. the service name
+func (e Event) WithEntityID(id string) Event {
+	e.data.ID = id
+	return e
+}
+
+// WithHost returns a copy of the event with the host set
+func (e Event) WithHost(host string) Event {
+	e.data.Host = host
+	return e
+}
+
+// WithField returns a copy of the event with a custom field added
+func (e Event) WithField(key, value string) Event {
+	fields := make(map[string]string, len(e.data.Fields)+1)
+	for k, v := range e.data.Fields {
+		fields[k] = v
+	}
+	fields[key] = value
+
+	e.data.Fields = fields
+	return e
+}
+
+// Data returns the event payload sent to the agent
+func (e Event) Data() EventData {
+	return e.data
+}
+
+// SendEvent sends the event to the agent and waits for its response. Transient failures,
+// such as network errors or 5xx responses, are retried a few times unless ctx is done.
+func SendEvent(ctx context.Context, e Event) error {
+	if e.data.Title == "" {
+		return errors.New("instana: event title is required")
+	}
+
+	if sensor == nil {
+		InitSensor(&Options{})
+	}
+
+	data := e.data
+
+	return deliverEvent(ctx, sensor.agent.clock(), sensor.agent.transport, &data)
+}
+
 // SendDefaultServiceEvent sends a default event which already contains the service and host
-func SendDefaultServiceEvent(title string, text string, sev severity, duration time.Duration) {
+func SendDefaultServiceEvent(title string, text string, sev Severity, duration time.Duration) {
 	if sensor == nil {
 		// Since no sensor was initialized, there is no default service (as
 		// configured on the sensor) so we send blank.
@@ -44,35 +146,82 @@ func SendDefaultServiceEvent(title string, text string, sev severity, duration t
 }
 
 // SendServiceEvent send an event on a specific service
-func SendServiceEvent(service string, title string, text string, sev severity, duration time.Duration) {
-	sendEvent(&EventData{
-		Title:    title,
-		Text:     text,
-		Severity: int(sev),
-		Plugin:   ServicePlugin,
-		ID:       service,
-		Host:     ServiceHost,
-		Duration: int(duration / time.Millisecond),
-	})
+func SendServiceEvent(service string, title string, text string, sev Severity, duration time.Duration) {
+	sendEvent(NewEvent(title).
+		WithText(text).
+		WithSeverity(sev).
+		WithPlugin(ServicePlugin).
+		WithEntityID(service).
+		WithHost(ServiceHost).
+		WithDuration(duration))
 }
 
 // SendHostEvent send an event on the current host
-func SendHostEvent(title string, text string, sev severity, duration time.Duration) {
-	sendEvent(&EventData{
-		Title:    title,
-		Text:     text,
-		Duration: int(duration / time.Millisecond),
-		Severity: int(sev),
-	})
+func SendHostEvent(title string, text string, sev Severity, duration time.Duration) {
+	sendEvent(NewEvent(title).
+		WithText(text).
+		WithSeverity(sev).
+		WithDuration(duration))
 }
 
-func sendEvent(event *EventData) {
+func sendEvent(e Event) {
 	if sensor == nil {
 		// If the sensor hasn't initialized we do so here so that we properly
 		// discover where the host agent may be as it varies between a
 		// normal host, docker, kubernetes etc..
 		InitSensor(&Options{})
 	}
+
+	data := e.data
+
 	//we do fire & forget here, because the whole pid dance isn't necessary to send events
-	go sensor.agent.request(sensor.agent.makeURL(agentEventURL), "POST", event)
+	sensor.agent.events.submit(&data)
+}
+
+// deliverEvent sends the event using given transport retrying transient failures. The clock
+// is used to wait between the attempts.
+func deliverEvent(ctx context.Context, clock Clock, t Transport, data *EventData) error {
+	var err error
+
+	backoff := eventRetryBackoff
+	for attempt := 1; ; attempt++ {
+		if err = t.SendEvent(ctx, data); err == nil || attempt == eventMaxAttempts || !isTransientError(err) {
+			return err
+		}
+
+		// the request might have failed because the context is done
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.debug("failed to send event, retrying in", backoff, err)
+
+		timer := clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+			backoff *= 2
+		}
+	}
+}
+
+// isTransientError returns true if the request that resulted in err is worth retrying
+func isTransientError(err error) bool {
+	// the HTTP client wraps the errors that occurred while sending the request
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	if e, ok := err.(*agentStatusError); ok {
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+	}
+
+	// any other error means the agent could not be reached
+	return true
 }
@@ -1,16 +1,23 @@
 package instana
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEventBasic(t *testing.T) {
-	assert.Equal(t, severity(-1), SeverityChange, "SeverityChange wrong value...")
-	assert.Equal(t, severity(5), SeverityWarning, "SeverityWarning wrong value...")
-	assert.Equal(t, severity(10), SeverityCritical, "SeverityCritical wrong value...")
+	assert.Equal(t, Severity(-1), SeverityChange, "SeverityChange wrong value...")
+	assert.Equal(t, Severity(5), SeverityWarning, "SeverityWarning wrong value...")
+	assert.Equal(t, Severity(10), SeverityCritical, "SeverityCritical wrong value...")
 }
 func TestEventDefault(t *testing.T) {
 	SendDefaultServiceEvent("microservice-14c", "These are event details",
@@ -26,3 +33,187 @@ func TestSendHostEvent(t *testing.T) {
 	SendHostEvent("microservice-14c", "r u listening?",
 		SeverityWarning, 500*time.Millisecond)
 }
+
+func TestEventBuilder(t *testing.T) {
+	base := NewEvent("Deployment").
+		WithSeverity(SeverityWarning).
+		WithPlugin(ServicePlugin).
+		WithEntityID("billing").
+		WithHost("host-1").
+		WithField("team", "payments")
+
+	e := base.
+		WithText("Released v1.2.3").
+		WithDuration(2*time.Second).
+		WithField("version", "1.2.3")
+
+	assert.Equal(t, EventData{
+		Title:    "Deployment",
+		Text:     "Released v1.2.3",
+		Duration: 2000,
+		Severity: 5,
+		Plugin:   ServicePlugin,
+		ID:       "billing",
+		Host:     "host-1",
+		Fields:   map[string]string{"team": "payments", "version": "1.2.3"},
+	}, e.Data())
+
+	// the template event is not affected
+	assert.Equal(t, "", base.Data().Text)
+	assert.Equal(t, map[string]string{"team": "payments"}, base.Data().Fields)
+
+	assert.Equal(t, int(SeverityChange), NewEvent("Change").Data().Severity)
+}
+
+func TestSendEvent(t *testing.T) {
+	tr := NewInMemoryTransport(1234)
+
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{agent: &agentS{transport: tr}}
+
+	require.NoError(t, SendEvent(context.Background(), NewEvent("Deployment").WithText("Released v1.2.3")))
+	require.Len(t, tr.Events(), 1)
+	assert.Equal(t, &EventData{Title: "Deployment", Text: "Released v1.2.3", Severity: -1}, recordedEvent(t, tr, 0))
+
+	assert.Error(t, SendEvent(context.Background(), NewEvent("")))
+
+	tr.SetError(&agentStatusError{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"})
+	assert.Error(t, SendEvent(context.Background(), NewEvent("Deployment")))
+	assert.Len(t, tr.Events(), 1)
+}
+
+func TestDeliverEventRetries(t *testing.T) {
+	tests := map[string]struct {
+		statusCodes      []int
+		expectedAttempts int32
+		expectError      bool
+	}{
+		"success": {
+			statusCodes:      []int{http.StatusOK},
+			expectedAttempts: 1,
+		},
+		"transient failure": {
+			statusCodes:      []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			expectedAttempts: 3,
+		},
+		"too many failures": {
+			statusCodes:      []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			expectedAttempts: 3,
+			expectError:      true,
+		},
+		"client error": {
+			statusCodes:      []int{http.StatusBadRequest, http.StatusOK},
+			expectedAttempts: 1,
+			expectError:      true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var attempts int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(test.statusCodes[n-1])
+			}))
+			defer srv.Close()
+
+			agent := newTestAgent(t, srv, &Options{})
+
+			err := deliverEvent(context.Background(), SystemClock, &agentTransport{agent: agent}, &EventData{Title: "test"})
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, test.expectedAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestDeliverEventTimeout(t *testing.T) {
+	var attempts int32
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	agent := newTestAgent(t, srv, &Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := deliverEvent(ctx, SystemClock, &agentTransport{agent: agent}, &EventData{Title: "test"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestDeliverEventRetryBackoff(t *testing.T) {
+	var attempts int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	clock := newManualClock(time.Now())
+	agent := newTestAgent(t, srv, &Options{Clock: clock})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- deliverEvent(context.Background(), agent.clock(), &agentTransport{agent: agent}, &EventData{Title: "test"})
+	}()
+
+	// the event is retried only once the backoff interval has passed on the clock
+	require.Eventually(t, func() bool { return clock.Pending() > 0 }, time.Second, 10*time.Millisecond)
+
+	clock.Advance(eventRetryBackoff - time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	clock.Advance(time.Millisecond)
+
+	require.NoError(t, <-done)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
+
+func TestDeliverEventCancelled(t *testing.T) {
+	tr := NewInMemoryTransport(1234)
+	tr.SetError(errors.New("connection refused"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, deliverEvent(ctx, SystemClock, tr, &EventData{Title: "test"}))
+	assert.Empty(t, tr.Events())
+}
+
+func TestIsTransientError(t *testing.T) {
+	examples := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"connection refused": {errors.New("connection refused"), true},
+		"server error":       {&agentStatusError{StatusCode: http.StatusBadGateway}, true},
+		"too many requests":  {&agentStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		"client error":       {&agentStatusError{StatusCode: http.StatusBadRequest}, false},
+		"cancelled":          {context.Canceled, false},
+		"timed out request":  {&url.Error{Op: "Post", URL: "http://localhost", Err: context.DeadlineExceeded}, false},
+		"cancelled request":  {&url.Error{Op: "Post", URL: "http://localhost", Err: context.Canceled}, false},
+	}
+
+	for name, example := range examples {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, example.expected, isTransientError(example.err))
+		})
+	}
+}
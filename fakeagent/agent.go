@@ -0,0 +1,393 @@
+// Package fakeagent provides an in-process Instana host agent to be used in integration tests. The agent
+// answers the host lookup, discovery, ping and data requests sent by the sensor and records them for
+// assertions. It can also simulate an agent that is not ready to accept the process, responds slowly
+// or fails.
+//
+//	agent := fakeagent.New()
+//	defer agent.Close()
+//
+//	instana.InitSensor(&instana.Options{AgentEndpoint: agent.URL()})
+package fakeagent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerHeader is the value of Server response header sent by the Instana host agent
+const ServerHeader = "Instana Agent"
+
+// DefaultPID is the process ID returned in the announce response unless changed with SetAnnounceResponse()
+const DefaultPID = 1234
+
+// Endpoint is a kind of request sent by the sensor to the agent
+type Endpoint string
+
+// Agent endpoints
+const (
+	Lookup         Endpoint = "lookup"
+	Discovery      Endpoint = "discovery"
+	Ping           Endpoint = "ping"
+	Traces         Endpoint = "traces"
+	Metrics        Endpoint = "metrics"
+	Events         Endpoint = "events"
+	AgentRequests  Endpoint = "agent_requests"
+	AgentResponses Endpoint = "agent_responses"
+	Unknown        Endpoint = "unknown"
+)
+
+const (
+	discoveryPath        = "/com.instana.plugin.golang.discovery"
+	tracesPrefix         = "/com.instana.plugin.golang/traces."
+	dataPrefix           = "/com.instana.plugin.golang."
+	eventPath            = "/com.instana.plugin.generic.event"
+	agentRequestsPrefix  = "/com.instana.plugin.golang/request."
+	agentResponsesPrefix = "/com.instana.plugin.golang/response."
+)
+
+// Request is a request received by the agent
+type Request struct {
+	Endpoint Endpoint
+	Method   string
+	// Path is the request path including the query string
+	Path   string
+	Header http.Header
+	// Body contains the request body with Content-Encoding removed
+	Body []byte
+	// Status is the response status code sent by the agent
+	Status     int
+	ReceivedAt time.Time
+}
+
+// Agent is a fake Instana host agent listening on a local port. It is safe for concurrent use.
+type Agent struct {
+	srv *httptest.Server
+
+	mu               sync.Mutex
+	announceResponse interface{}
+	announced        map[string]bool
+	rejectAnnounces  int
+	delay            time.Duration
+	failures         map[Endpoint]int
+	requests         []Request
+}
+
+// New starts a new fake agent that accepts the announcements right away
+func New() *Agent {
+	a := &Agent{
+		announceResponse: map[string]interface{}{
+			"pid":       DefaultPID,
+			"agentUuid": "fake-agent",
+		},
+		announced: make(map[string]bool),
+		failures:  make(map[Endpoint]int),
+	}
+	a.srv = httptest.NewServer(http.HandlerFunc(a.serveHTTP))
+
+	return a
+}
+
+// URL returns the agent URL to be used as instana.Options.AgentEndpoint
+func (a *Agent) URL() string {
+	return a.srv.URL
+}
+
+// Host returns the host the agent is listening on
+func (a *Agent) Host() string {
+	host, _, _ := net.SplitHostPort(a.srv.Listener.Addr().String())
+
+	return host
+}
+
+// Port returns the port the agent is listening on
+func (a *Agent) Port() int {
+	_, port, _ := net.SplitHostPort(a.srv.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+
+	return n
+}
+
+// Close shuts the agent down and blocks until all pending requests are finished
+func (a *Agent) Close() {
+	a.srv.Close()
+}
+
+// SetAnnounceResponse changes the payload sent in response to the discovery request. The value
+// is marshaled to JSON, and its "pid" field is used to accept pings and data requests.
+func (a *Agent) SetAnnounceResponse(resp interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.announceResponse = resp
+}
+
+// RejectAnnouncements makes the agent respond with 404 Not Found to the next n discovery requests,
+// as the agent does while it is not yet ready to accept the process
+func (a *Agent) RejectAnnouncements(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rejectAnnounces = n
+}
+
+// SetDelay makes the agent wait for d before responding to each request. A zero value
+// disables the delay.
+func (a *Agent) SetDelay(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.delay = d
+}
+
+// SetFailure makes the agent respond to all requests to given endpoint with status until it is
+// reset with 0
+func (a *Agent) SetFailure(ep Endpoint, status int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if status == 0 {
+		delete(a.failures, ep)
+		return
+	}
+
+	a.failures[ep] = status
+}
+
+// Requests returns all requests received by the agent in order of their arrival
+func (a *Agent) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]Request(nil), a.requests...)
+}
+
+// RequestsTo returns the requests received by given endpoint
+func (a *Agent) RequestsTo(ep Endpoint) []Request {
+	var reqs []Request
+	for _, req := range a.Requests() {
+		if req.Endpoint == ep {
+			reqs = append(reqs, req)
+		}
+	}
+
+	return reqs
+}
+
+// Discoveries returns the payloads of successful discovery requests
+func (a *Agent) Discoveries() []map[string]interface{} {
+	return a.decodeObjects(Discovery)
+}
+
+// Spans returns all spans accepted by the agent
+func (a *Agent) Spans() []map[string]interface{} {
+	return a.decodeArrays(Traces)
+}
+
+// Metrics returns the payloads of accepted metrics requests
+func (a *Agent) Metrics() []map[string]interface{} {
+	return a.decodeObjects(Metrics)
+}
+
+// Events returns all events accepted by the agent
+func (a *Agent) Events() []map[string]interface{} {
+	return a.decodeObjects(Events)
+}
+
+// Reset discards all received requests
+func (a *Agent) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.requests = nil
+}
+
+func (a *Agent) decodeObjects(ep Endpoint) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, req := range a.accepted(ep) {
+		var v map[string]interface{}
+		if err := json.Unmarshal(req.Body, &v); err == nil {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func (a *Agent) decodeArrays(ep Endpoint) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, req := range a.accepted(ep) {
+		var v []map[string]interface{}
+		if err := json.Unmarshal(req.Body, &v); err == nil {
+			result = append(result, v...)
+		}
+	}
+
+	return result
+}
+
+func (a *Agent) accepted(ep Endpoint) []Request {
+	var reqs []Request
+	for _, req := range a.RequestsTo(ep) {
+		if req.Status >= 200 && req.Status < 300 {
+			reqs = append(reqs, req)
+		}
+	}
+
+	return reqs
+}
+
+func (a *Agent) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	rec := Request{
+		Endpoint:   classify(req),
+		Method:     req.Method,
+		Path:       req.URL.RequestURI(),
+		Header:     req.Header.Clone(),
+		ReceivedAt: time.Now(),
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		rec.Status = http.StatusBadRequest
+	} else {
+		rec.Body = body
+	}
+
+	a.mu.Lock()
+	delay := a.delay
+	a.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	var resp []byte
+	if rec.Status == 0 {
+		rec.Status, resp = a.respond(rec)
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, rec)
+	a.mu.Unlock()
+
+	w.Header().Set("Server", ServerHeader)
+	if resp != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	w.WriteHeader(rec.Status)
+	w.Write(resp)
+}
+
+// respond returns the status code and the response body for a request
+func (a *Agent) respond(req Request) (int, []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if status, ok := a.failures[req.Endpoint]; ok {
+		return status, nil
+	}
+
+	switch req.Endpoint {
+	case Lookup:
+		return http.StatusOK, []byte(`{"version":"fake"}`)
+	case Discovery:
+		if req.Method != http.MethodPut {
+			return http.StatusMethodNotAllowed, nil
+		}
+
+		if a.rejectAnnounces > 0 {
+			a.rejectAnnounces--
+			return http.StatusNotFound, nil
+		}
+
+		resp, err := json.Marshal(a.announceResponse)
+		if err != nil {
+			return http.StatusInternalServerError, nil
+		}
+
+		var announced struct {
+			PID json.Number `json:"pid"`
+		}
+		json.Unmarshal(resp, &announced)
+		a.announced[announced.PID.String()] = true
+
+		return http.StatusOK, resp
+	case Ping, Traces, Metrics, AgentRequests, AgentResponses:
+		// the agent only accepts data from announced processes
+		if !a.announced[pidFromPath(req.Path)] {
+			return http.StatusNotFound, nil
+		}
+
+		if req.Endpoint == AgentRequests {
+			return http.StatusOK, []byte("[]")
+		}
+
+		return http.StatusOK, nil
+	case Events:
+		return http.StatusNoContent, nil
+	default:
+		return http.StatusNotFound, nil
+	}
+}
+
+func classify(req *http.Request) Endpoint {
+	path := req.URL.Path
+
+	switch {
+	case path == "/" && req.Method == http.MethodGet:
+		return Lookup
+	case path == discoveryPath:
+		return Discovery
+	case path == eventPath:
+		return Events
+	case strings.HasPrefix(path, tracesPrefix):
+		return Traces
+	case strings.HasPrefix(path, agentRequestsPrefix):
+		return AgentRequests
+	case strings.HasPrefix(path, agentResponsesPrefix):
+		return AgentResponses
+	case strings.HasPrefix(path, dataPrefix) && req.Method == http.MethodHead:
+		return Ping
+	case strings.HasPrefix(path, dataPrefix):
+		return Metrics
+	default:
+		return Unknown
+	}
+}
+
+// pidFromPath returns the process ID from the request path, i.e. "1234" for /com.instana.plugin.golang.1234
+func pidFromPath(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	return path[strings.LastIndexByte(path, '.')+1:]
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Header.Get("Content-Encoding") != "gzip" {
+		return body, nil
+	}
+
+	gr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	return ioutil.ReadAll(gr)
+}
@@ -0,0 +1,146 @@
+package fakeagent_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/instana/go-sensor/fakeagent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doRequest(t *testing.T, method, url string, body []byte, header http.Header) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	return resp
+}
+
+func TestAgent_Announce(t *testing.T) {
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	resp := doRequest(t, http.MethodGet, agent.URL()+"/", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Instana Agent", resp.Header.Get("Server"))
+
+	// data is not accepted from processes that have not been announced
+	resp = doRequest(t, http.MethodHead, agent.URL()+"/com.instana.plugin.golang.1234", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPut, agent.URL()+"/com.instana.plugin.golang.discovery", []byte(`{"pid":42}`), nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodHead, agent.URL()+"/com.instana.plugin.golang.1234", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, []map[string]interface{}{{"pid": float64(42)}}, agent.Discoveries())
+
+	var endpoints []fakeagent.Endpoint
+	for _, req := range agent.Requests() {
+		endpoints = append(endpoints, req.Endpoint)
+	}
+	assert.Equal(t, []fakeagent.Endpoint{fakeagent.Lookup, fakeagent.Ping, fakeagent.Discovery, fakeagent.Ping}, endpoints)
+}
+
+func TestAgent_RejectAnnouncements(t *testing.T) {
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	agent.SetAnnounceResponse(map[string]interface{}{"pid": 5678})
+	agent.RejectAnnouncements(2)
+
+	for _, expected := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusOK} {
+		resp := doRequest(t, http.MethodPut, agent.URL()+"/com.instana.plugin.golang.discovery", []byte(`{"pid":42}`), nil)
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+
+	assert.Len(t, agent.RequestsTo(fakeagent.Discovery), 3)
+	assert.Len(t, agent.Discoveries(), 1)
+
+	resp := doRequest(t, http.MethodHead, agent.URL()+"/com.instana.plugin.golang.5678", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestAgent_Data(t *testing.T) {
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	doRequest(t, http.MethodPut, agent.URL()+"/com.instana.plugin.golang.discovery", []byte(`{"pid":42}`), nil)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(`[{"n":"sdk"},{"n":"g.http"}]`))
+	require.NoError(t, gw.Close())
+
+	resp := doRequest(t, http.MethodPost, agent.URL()+"/com.instana.plugin.golang/traces.1234", buf.Bytes(), http.Header{
+		"Content-Encoding": []string{"gzip"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPost, agent.URL()+"/com.instana.plugin.golang.1234", []byte(`{"pid":1234}`), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodPost, agent.URL()+"/com.instana.plugin.generic.event", []byte(`{"title":"test"}`), nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doRequest(t, http.MethodGet, agent.URL()+"/com.instana.plugin.golang/request.1234", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, []map[string]interface{}{{"n": "sdk"}, {"n": "g.http"}}, agent.Spans())
+	assert.Equal(t, []map[string]interface{}{{"pid": float64(1234)}}, agent.Metrics())
+	assert.Equal(t, []map[string]interface{}{{"title": "test"}}, agent.Events())
+	assert.Len(t, agent.RequestsTo(fakeagent.AgentRequests), 1)
+
+	agent.Reset()
+	assert.Empty(t, agent.Requests())
+}
+
+func TestAgent_SetFailure(t *testing.T) {
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	doRequest(t, http.MethodPut, agent.URL()+"/com.instana.plugin.golang.discovery", []byte(`{"pid":42}`), nil)
+
+	agent.SetFailure(fakeagent.Traces, http.StatusServiceUnavailable)
+
+	resp := doRequest(t, http.MethodPost, agent.URL()+"/com.instana.plugin.golang/traces.1234", []byte(`[{"n":"sdk"}]`), nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, agent.Spans())
+
+	agent.SetFailure(fakeagent.Traces, 0)
+
+	resp = doRequest(t, http.MethodPost, agent.URL()+"/com.instana.plugin.golang/traces.1234", []byte(`[{"n":"sdk"}]`), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, agent.Spans(), 1)
+}
+
+func TestAgent_SetDelay(t *testing.T) {
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	agent.SetDelay(100 * time.Millisecond)
+
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, err := client.Get(agent.URL() + "/")
+	assert.Error(t, err)
+
+	agent.SetDelay(0)
+
+	resp, err := client.Get(agent.URL() + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -1,11 +1,9 @@
 package instana
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"net"
 	"os"
-	"regexp"
 	"strconv"
 	"time"
 
@@ -25,7 +23,7 @@ const (
 type fsmS struct {
 	agent   *agentS
 	fsm     *f.FSM
-	timer   *time.Timer
+	timer   Timer
 	retries int
 }
 
@@ -44,21 +42,43 @@ func (r *fsmS) init() {
 		f.Callbacks{
 			"init":              r.lookupAgentHost,
 			"enter_unannounced": r.announceSensor,
-			"enter_announced":   r.testAgent})
+			"enter_announced":   r.testAgent,
+			"enter_ready":       r.agentReady,
+			"leave_ready":       r.agentLost})
 
 	r.retries = maximumRetries
 	r.fsm.Event(eInit)
 }
 
 func (r *fsmS) scheduleRetry(e *f.Event, cb func(e *f.Event)) {
-	r.timer = time.NewTimer(retryPeriod * time.Millisecond)
+	r.timer = r.agent.sensor.options.Clock.NewTimer(retryPeriod * time.Millisecond)
 	go func() {
-		<-r.timer.C
+		<-r.timer.C()
 		cb(e)
 	}()
 }
 
 func (r *fsmS) lookupAgentHost(e *f.Event) {
+	// Custom transports are responsible for locating their destination
+	if !r.agent.usesHostAgent() {
+		go r.lookupSuccess("")
+		return
+	}
+
+	// There is no point in looking elsewhere if the agent endpoint is configured explicitly
+	if ep := r.agent.hostEndpoint; ep != nil {
+		go r.checkHost(ep.host, func(b bool, host string) {
+			if b {
+				r.lookupSuccess(host)
+			} else {
+				log.error("Cannot connect to the agent through the configured endpoint. Scheduling retry.")
+				r.scheduleRetry(e, r.lookupAgentHost)
+			}
+		})
+
+		return
+	}
+
 	cb := func(b bool, host string) {
 		if b {
 			r.lookupSuccess(host)
@@ -109,10 +129,11 @@ func (r *fsmS) lookupSuccess(host string) {
 }
 
 func (r *fsmS) announceSensor(e *f.Event) {
-	cb := func(b bool, from *fromS) {
+	cb := func(b bool, from *fromS, resp *agentResponse) {
 		if b {
 			log.info("Host agent available. We're in business. Announced pid:", from.PID)
 			r.agent.setFrom(from)
+			r.agent.applyConfig(resp)
 			r.retries = maximumRetries
 			r.fsm.Event(eAnnounce)
 		} else {
@@ -128,49 +149,36 @@ func (r *fsmS) announceSensor(e *f.Event) {
 
 	log.debug("announcing sensor to the agent")
 
-	go func(cb func(b bool, from *fromS)) {
+	go func(cb func(b bool, from *fromS, resp *agentResponse)) {
 		defer func() {
 			if r := recover(); r != nil {
 				log.debug("Announce recovered:", r)
 			}
 		}()
 
-		pid := 0
-		schedFile := fmt.Sprintf("/proc/%d/sched", os.Getpid())
-		if _, err := os.Stat(schedFile); err == nil {
-			sf, err := os.Open(schedFile)
-			defer sf.Close()
-			if err == nil {
-				fscanner := bufio.NewScanner(sf)
-				fscanner.Scan()
-				primaLinea := fscanner.Text()
-
-				r := regexp.MustCompile("\\((\\d+),")
-				match := r.FindStringSubmatch(primaLinea)
-				i, err := strconv.Atoi(match[1])
-				if err == nil {
-					pid = i
-				}
-			}
-		}
+		pidInfo := resolveProcessPID("/proc")
+		log.debug("resolved process PID", pidInfo.PID, "using", pidInfo.Method)
 
-		if pid == 0 {
-			pid = os.Getpid()
+		d := &discoveryS{
+			PID:          pidInfo.PID,
+			PIDFrom:      pidInfo.Method,
+			ContainerID:  pidInfo.ContainerID,
+			PIDNamespace: pidInfo.PIDNamespace,
 		}
-
-		d := &discoveryS{PID: pid}
 		d.Name, d.Args = getCommandLine()
 
-		if _, err := os.Stat("/proc"); err == nil {
-			if addr, err := net.ResolveTCPAddr("tcp", r.agent.host+":42699"); err == nil {
-				if tcpConn, err := net.DialTCP("tcp", nil, addr); err == nil {
-					defer tcpConn.Close()
+		if _, err := os.Stat("/proc"); err == nil && r.agent.usesHostAgent() {
+			if conn, err := r.agent.hostEndpoint.dial(context.Background(), r.agent.host, r.agent.port()); err == nil {
+				defer conn.Close()
 
-					f, err := tcpConn.File()
+				// both *net.TCPConn and *net.UnixConn provide access to the underlying file
+				if fc, ok := conn.(interface{ File() (*os.File, error) }); ok {
+					f, err := fc.File()
 
 					if err != nil {
 						log.error(err)
 					} else {
+						defer f.Close()
 						d.Fd = fmt.Sprintf("%v", f.Fd())
 
 						link := fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), f.Fd())
@@ -183,11 +191,12 @@ func (r *fsmS) announceSensor(e *f.Event) {
 		}
 
 		ret := &agentResponse{}
-		_, err := r.agent.requestResponse(r.agent.makeURL(agentDiscoveryURL), "PUT", d, ret)
+		err := r.agent.transport.Announce(context.Background(), d, ret)
 		cb(err == nil,
 			&fromS{
 				PID:    strconv.Itoa(int(ret.Pid)),
-				HostID: ret.HostID})
+				HostID: ret.HostID},
+			ret)
 	}(cb)
 }
 
@@ -210,24 +219,37 @@ func (r *fsmS) testAgent(e *f.Event) {
 	log.debug("testing communication with the agent")
 
 	go func(cb func(b bool)) {
-		_, err := r.agent.head(r.agent.makeURL(agentDataURL))
+		err := r.agent.transport.Ping(context.Background())
 		cb(err == nil)
 	}(cb)
 }
 
+func (r *fsmS) agentReady(e *f.Event) {
+	r.agent.lifecycle.ready()
+}
+
+func (r *fsmS) agentLost(e *f.Event) {
+	log.debug("lost connection to the agent")
+	r.agent.lifecycle.agentLost()
+}
+
 func (r *fsmS) reset() {
 	r.retries = maximumRetries
 	r.fsm.Event(eInit)
 }
 
-func (r *agentS) initFsm() *fsmS {
-	ret := new(fsmS)
-	ret.agent = r
-	ret.init()
-
-	return ret
+func (r *agentS) initFsm() {
+	// the agent's reference to the FSM is set before the initial transition, since the
+	// callbacks are run asynchronously and access it
+	r.fsm = new(fsmS)
+	r.fsm.agent = r
+	r.fsm.init()
 }
 
 func (r *agentS) canSend() bool {
+	if r.endpoint != nil {
+		return true
+	}
+
 	return r.fsm.fsm.Current() == "ready"
 }
@@ -0,0 +1,100 @@
+package instana
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/instana/go-sensor/fakeagent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsm_AnnounceToFakeAgent(t *testing.T) {
+	InitSensor(&Options{})
+
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	agent.SetAnnounceResponse(map[string]interface{}{
+		"pid":          5678,
+		"agentUuid":    "agent1",
+		"extraHeaders": []string{"X-Request-Id"},
+	})
+
+	s := &sensorS{serviceName: "test"}
+	s.setOptions(&Options{AgentEndpoint: agent.URL()})
+
+	a := s.initAgent()
+	require.Eventually(t, a.canSend, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, a.transport.SendSpans(context.Background(), []jsonSpan{{TraceID: 1, SpanID: 2, Name: "sdk"}}))
+	require.NoError(t, a.transport.SendMetrics(context.Background(), map[string]interface{}{"pid": 5678}))
+
+	discoveries := agent.Discoveries()
+	require.Len(t, discoveries, 1)
+	assert.Equal(t, float64(resolveProcessPID("/proc").PID), discoveries[0]["pid"])
+
+	assert.NotEmpty(t, agent.RequestsTo(fakeagent.Lookup))
+	require.NotEmpty(t, agent.RequestsTo(fakeagent.Ping))
+	assert.Equal(t, "/com.instana.plugin.golang.5678", agent.RequestsTo(fakeagent.Ping)[0].Path)
+
+	spans := agent.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "sdk", spans[0]["n"])
+
+	assert.Len(t, agent.Metrics(), 1)
+	assert.Equal(t, []string{"X-Request-Id"}, a.config.get().extraHTTPHeaders)
+}
+
+func TestFsm_AgentFailure(t *testing.T) {
+	InitSensor(&Options{})
+
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	s := &sensorS{serviceName: "test"}
+	s.setOptions(&Options{AgentEndpoint: agent.URL()})
+
+	a := s.initAgent()
+	require.Eventually(t, a.canSend, 5*time.Second, 10*time.Millisecond)
+
+	agent.SetFailure(fakeagent.Traces, http.StatusServiceUnavailable)
+
+	err := a.transport.SendSpans(context.Background(), []jsonSpan{{TraceID: 1, SpanID: 2, Name: "sdk"}})
+	require.Error(t, err)
+
+	statusErr, ok := err.(*agentStatusError)
+	require.True(t, ok, "unexpected error type %T", err)
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+
+	assert.Empty(t, agent.Spans())
+}
+
+func TestFsm_AnnounceRetry(t *testing.T) {
+	InitSensor(&Options{})
+
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	agent.RejectAnnouncements(1)
+
+	clock := newManualClock(time.Now())
+
+	s := &sensorS{serviceName: "test"}
+	s.setOptions(&Options{AgentEndpoint: agent.URL(), Clock: clock})
+
+	a := s.initAgent()
+
+	// the first announcement is rejected and retried after the retry period
+	require.Eventually(t, func() bool { return clock.Pending() > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, agent.RequestsTo(fakeagent.Discovery), 1)
+	assert.False(t, a.canSend())
+
+	clock.Advance(retryPeriod * time.Millisecond)
+
+	require.Eventually(t, a.canSend, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, agent.RequestsTo(fakeagent.Discovery), 2)
+	assert.Len(t, agent.Discoveries(), 1)
+}
@@ -0,0 +1,32 @@
+package instanatest
+
+// TestingT is the subset of testing.TB used by the assertion helpers
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertSpan checks that a span with given operation name has been recorded and returns it
+func (r *Recorder) AssertSpan(t TestingT, operation string) (Span, bool) {
+	t.Helper()
+
+	sp, ok := r.FindSpan(operation)
+	if !ok {
+		t.Errorf("no span %q has been recorded, got:\n%s", operation, r.Tree())
+	}
+
+	return sp, ok
+}
+
+// AssertChildOf checks that child span belongs to the same trace as parent and has parent
+// as its direct parent
+func (r *Recorder) AssertChildOf(t TestingT, child, parent Span) bool {
+	t.Helper()
+
+	if child.TraceID != parent.TraceID || child.ParentID != parent.SpanID {
+		t.Errorf("span %q is not a child of %q, got:\n%s", child.Operation, parent.Operation, r.Tree())
+		return false
+	}
+
+	return true
+}
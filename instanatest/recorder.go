@@ -0,0 +1,188 @@
+// Package instanatest provides a tracer that records finished spans in memory along with
+// the helpers to inspect them in tests. Unlike instana.NewTestRecorder(), the tracer created
+// by this package does not initialize the sensor and does not connect to the host agent.
+//
+//	sensor, recorder := instanatest.NewSensor()
+//	http.HandleFunc("/", sensor.TracingHandler("index", index))
+//
+//	// ...
+//
+//	sp, ok := recorder.FindSpan("index")
+package instanatest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	instana "github.com/instana/go-sensor"
+	ot "github.com/opentracing/opentracing-go"
+)
+
+// Span kinds
+const (
+	EntrySpan        = "entry"
+	ExitSpan         = "exit"
+	IntermediateSpan = "intermediate"
+)
+
+// Span is a finished span captured by Recorder
+type Span struct {
+	TraceID int64
+	SpanID  int64
+	// ParentID is 0 for the root span of a trace
+	ParentID  int64
+	Operation string
+	// Kind is one of EntrySpan, ExitSpan or IntermediateSpan
+	Kind     string
+	Start    time.Time
+	Duration time.Duration
+	Tags     map[string]interface{}
+	Logs     []LogRecord
+	Baggage  map[string]string
+	// Error is set if the span has been tagged with "error" or has an error logged
+	Error      bool
+	ErrorCount int
+}
+
+// LogRecord is a log entry of a captured span
+type LogRecord struct {
+	Timestamp time.Time
+	Fields    map[string]interface{}
+}
+
+func newSpan(sp instana.FinishedSpan) Span {
+	s := Span{
+		TraceID:    sp.TraceID,
+		SpanID:     sp.SpanID,
+		ParentID:   sp.ParentID,
+		Operation:  sp.Operation,
+		Kind:       sp.Kind,
+		Start:      sp.Start,
+		Duration:   sp.Duration,
+		Tags:       sp.Tags,
+		Baggage:    sp.Baggage,
+		Error:      sp.Error,
+		ErrorCount: sp.ErrorCount,
+	}
+
+	for _, lr := range sp.Logs {
+		fields := make(map[string]interface{}, len(lr.Fields))
+		for _, f := range lr.Fields {
+			fields[f.Key()] = f.Value()
+		}
+
+		s.Logs = append(s.Logs, LogRecord{
+			Timestamp: lr.Timestamp,
+			Fields:    fields,
+		})
+	}
+
+	return s
+}
+
+// Recorder captures the spans finished by a tracer created with NewTracer() or NewSensor().
+// It is safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+// NewRecorder returns a new empty Recorder. Use Recorder.SpanRecorder() to get an instana.SpanRecorder
+// that can be passed to instana.NewStandaloneTracer().
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewTracer returns a tracer that records spans in memory along with the recorder
+func NewTracer() (ot.Tracer, *Recorder) {
+	return NewTracerWithOptions(&instana.Options{})
+}
+
+// NewTracerWithOptions returns a tracer initialized with given options that records spans in memory
+// along with the recorder. The options not related to tracing are ignored.
+func NewTracerWithOptions(opts *instana.Options) (ot.Tracer, *Recorder) {
+	r := NewRecorder()
+
+	return instana.NewStandaloneTracer(opts, r.SpanRecorder()), r
+}
+
+// NewSensor returns a sensor to instrument HTTP handlers and clients that records spans in memory
+// along with the recorder
+func NewSensor() (*instana.Sensor, *Recorder) {
+	tracer, r := NewTracer()
+
+	return instana.NewSensorWithTracer(tracer), r
+}
+
+// SpanRecorder returns an instana.SpanRecorder that captures finished spans into r
+func (r *Recorder) SpanRecorder() instana.SpanRecorder {
+	return instana.SpanRecorderFunc(r.record)
+}
+
+func (r *Recorder) record(sp instana.FinishedSpan) {
+	s := newSpan(sp)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, s)
+}
+
+// Spans returns the spans recorded so far in order they have been finished
+func (r *Recorder) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Span(nil), r.spans...)
+}
+
+// Reset discards the recorded spans
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = nil
+}
+
+// FindSpan returns the first finished span with given operation name
+func (r *Recorder) FindSpan(operation string) (Span, bool) {
+	spans := r.FindSpans(operation)
+	if len(spans) == 0 {
+		return Span{}, false
+	}
+
+	return spans[0], true
+}
+
+// FindSpans returns all recorded spans with given operation name
+func (r *Recorder) FindSpans(operation string) []Span {
+	var found []Span
+	for _, sp := range r.Spans() {
+		if sp.Operation == operation {
+			found = append(found, sp)
+		}
+	}
+
+	return found
+}
+
+// Children returns the recorded child spans of parent ordered by their start time
+func (r *Recorder) Children(parent Span) []Span {
+	var children []Span
+	for _, sp := range r.Spans() {
+		if sp.TraceID == parent.TraceID && sp.ParentID == parent.SpanID {
+			children = append(children, sp)
+		}
+	}
+
+	sortByStart(children)
+
+	return children
+}
+
+func sortByStart(spans []Span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+}
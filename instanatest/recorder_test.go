@@ -0,0 +1,172 @@
+package instanatest_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	instana "github.com/instana/go-sensor"
+	"github.com/instana/go-sensor/instanatest"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder_FindSpan(t *testing.T) {
+	tracer, recorder := instanatest.NewTracer()
+
+	parent := tracer.StartSpan("parent", ext.SpanKindRPCServer)
+	parent.SetBaggageItem("user", "alice")
+
+	child := tracer.StartSpan("child", ot.ChildOf(parent.Context()), ext.SpanKindRPCClient, ot.Tag{Key: "db.statement", Value: "SELECT 1"})
+	child.LogFields(otlog.Error(errors.New("something went wrong")))
+	child.Finish()
+
+	parent.Finish()
+
+	require.Len(t, recorder.Spans(), 2)
+
+	p, ok := recorder.FindSpan("parent")
+	require.True(t, ok)
+
+	assert.Equal(t, instanatest.EntrySpan, p.Kind)
+	assert.Equal(t, int64(0), p.ParentID)
+	assert.Equal(t, map[string]string{"user": "alice"}, p.Baggage)
+	assert.False(t, p.Error)
+
+	c, ok := recorder.FindSpan("child")
+	require.True(t, ok)
+
+	assert.Equal(t, instanatest.ExitSpan, c.Kind)
+	assert.Equal(t, p.TraceID, c.TraceID)
+	assert.Equal(t, p.SpanID, c.ParentID)
+	assert.Equal(t, "SELECT 1", c.Tags["db.statement"])
+	assert.True(t, c.Error)
+	assert.Equal(t, 1, c.ErrorCount)
+
+	require.Len(t, c.Logs, 1)
+	assert.Equal(t, errors.New("something went wrong"), c.Logs[0].Fields["error"])
+
+	assert.Equal(t, []instanatest.Span{c}, recorder.Children(p))
+
+	_, ok = recorder.FindSpan("unknown")
+	assert.False(t, ok)
+}
+
+func TestRecorder_Reset(t *testing.T) {
+	tracer, recorder := instanatest.NewTracer()
+
+	tracer.StartSpan("test").Finish()
+	require.Len(t, recorder.Spans(), 1)
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Spans())
+}
+
+func TestNewSensor(t *testing.T) {
+	sensor, recorder := instanatest.NewSensor()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer upstream.Close()
+
+	h := sensor.TracingHandler("index", func(w http.ResponseWriter, req *http.Request) {
+		upstreamReq, err := http.NewRequest(http.MethodGet, upstream.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := sensor.TracingHttpRequest("upstream", req, upstreamReq, http.Client{})
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	// malformed trace headers are logged and ignored
+	req.Header.Set("X-Instana-T", "not a trace id")
+	req.Header.Set("X-Instana-S", "not a span id")
+
+	h(httptest.NewRecorder(), req)
+
+	entry, ok := recorder.AssertSpan(t, "index")
+	require.True(t, ok)
+
+	assert.Equal(t, instanatest.EntrySpan, entry.Kind)
+	assert.Equal(t, http.StatusCreated, entry.Tags[string(ext.HTTPStatusCode)])
+
+	exit, ok := recorder.AssertSpan(t, "client")
+	require.True(t, ok)
+
+	assert.Equal(t, instanatest.ExitSpan, exit.Kind)
+	recorder.AssertChildOf(t, exit, entry)
+}
+
+type testingT struct {
+	errors []string
+}
+
+func (t *testingT) Helper() {}
+
+func (t *testingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecorder_AssertChildOf(t *testing.T) {
+	tracer, recorder := instanatest.NewTracer()
+
+	root := tracer.StartSpan("root")
+	tracer.StartSpan("child", ot.ChildOf(root.Context())).Finish()
+	tracer.StartSpan("unrelated").Finish()
+	root.Finish()
+
+	rootSp, _ := recorder.FindSpan("root")
+	childSp, _ := recorder.FindSpan("child")
+	unrelatedSp, _ := recorder.FindSpan("unrelated")
+
+	mt := &testingT{}
+	assert.True(t, recorder.AssertChildOf(mt, childSp, rootSp))
+	assert.Empty(t, mt.errors)
+
+	assert.False(t, recorder.AssertChildOf(mt, unrelatedSp, rootSp))
+	require.Len(t, mt.errors, 1)
+	assert.Contains(t, mt.errors[0], `span "unrelated" is not a child of "root"`)
+	assert.Contains(t, mt.errors[0], recorder.Tree())
+
+	_, ok := recorder.AssertSpan(mt, "missing")
+	assert.False(t, ok)
+	assert.Len(t, mt.errors, 2)
+}
+
+func TestRecorder_Tree(t *testing.T) {
+	tracer, recorder := instanatest.NewTracer()
+
+	assert.Equal(t, "no spans recorded", recorder.Tree())
+
+	start := time.Now()
+
+	root := tracer.StartSpan("GET /", ext.SpanKindRPCServer, ot.StartTime(start))
+	db := tracer.StartSpan("SELECT", ot.ChildOf(root.Context()), ext.SpanKindRPCClient, ot.StartTime(start.Add(time.Millisecond)))
+	ext.Error.Set(db, true)
+	render := tracer.StartSpan("render", ot.ChildOf(root.Context()), ot.StartTime(start.Add(2*time.Millisecond)))
+	partial := tracer.StartSpan("partial", ot.ChildOf(render.Context()), ot.StartTime(start.Add(3*time.Millisecond)))
+
+	partial.Finish()
+	// render is not finished, so partial is rendered as a root
+	db.Finish()
+	root.Finish()
+
+	traceID, err := instana.ID2Header(root.Context().(instana.SpanContext).TraceID)
+	require.NoError(t, err)
+
+	renderID, err := instana.ID2Header(render.Context().(instana.SpanContext).SpanID)
+	require.NoError(t, err)
+
+	assert.Equal(t, "trace "+traceID+"\n"+
+		"├── GET / [entry]\n"+
+		"│   └── SELECT [exit] error\n"+
+		"└── partial [intermediate] (parent "+renderID+" not recorded)\n", recorder.Tree())
+}
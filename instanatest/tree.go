@@ -0,0 +1,91 @@
+package instanatest
+
+import (
+	"fmt"
+	"strings"
+
+	instana "github.com/instana/go-sensor"
+)
+
+// Tree renders the recorded spans as a set of trace trees to be used in test failure messages:
+//
+//	trace 1b2d3c4e5f607182
+//	└── GET / [entry]
+//	    ├── SELECT [exit] error
+//	    └── render [intermediate]
+//
+// Spans are ordered by their start time. The spans which parent has not been recorded are
+// rendered as roots.
+func (r *Recorder) Tree() string {
+	spans := r.Spans()
+	if len(spans) == 0 {
+		return "no spans recorded"
+	}
+
+	sortByStart(spans)
+
+	recorded := make(map[[2]int64]bool, len(spans))
+	for _, sp := range spans {
+		recorded[[2]int64{sp.TraceID, sp.SpanID}] = true
+	}
+
+	children := make(map[[2]int64][]Span)
+	roots := make(map[int64][]Span)
+
+	var traces []int64
+	for _, sp := range spans {
+		if sp.ParentID != 0 && recorded[[2]int64{sp.TraceID, sp.ParentID}] {
+			parent := [2]int64{sp.TraceID, sp.ParentID}
+			children[parent] = append(children[parent], sp)
+
+			continue
+		}
+
+		if _, ok := roots[sp.TraceID]; !ok {
+			traces = append(traces, sp.TraceID)
+		}
+		roots[sp.TraceID] = append(roots[sp.TraceID], sp)
+	}
+
+	var buf strings.Builder
+	for i, traceID := range traces {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+
+		fmt.Fprintf(&buf, "trace %s\n", formatID(traceID))
+		writeSubtree(&buf, roots[traceID], children, "")
+	}
+
+	return buf.String()
+}
+
+func writeSubtree(buf *strings.Builder, spans []Span, children map[[2]int64][]Span, indent string) {
+	for i, sp := range spans {
+		branch, nextIndent := "├── ", indent+"│   "
+		if i == len(spans)-1 {
+			branch, nextIndent = "└── ", indent+"    "
+		}
+
+		buf.WriteString(indent + branch + sp.Operation + " [" + sp.Kind + "]")
+		if sp.Error {
+			buf.WriteString(" error")
+		}
+
+		if sp.ParentID != 0 && indent == "" {
+			buf.WriteString(" (parent " + formatID(sp.ParentID) + " not recorded)")
+		}
+		buf.WriteByte('\n')
+
+		writeSubtree(buf, children[[2]int64{sp.TraceID, sp.SpanID}], children, nextIndent)
+	}
+}
+
+func formatID(id int64) string {
+	s, err := instana.ID2Header(id)
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+
+	return s
+}
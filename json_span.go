@@ -1,6 +1,8 @@
 package instana
 
 import (
+	"sync"
+
 	ot "github.com/opentracing/opentracing-go"
 )
 
@@ -17,17 +19,65 @@ type jsonSpan struct {
 	Ec        int       `json:"ec,omitempty"`
 	Lang      string    `json:"ta,omitempty"`
 	Data      *jsonData `json:"data"`
+
+	// pooled is set if the span data has been taken from spanDataPool
+	pooled *spanData
+}
+
+// spanData holds the structures used to report a span, so that they are allocated at once.
+// If span pooling is enabled, it is taken from spanDataPool and returned there once the span
+// has been sent to the agent.
+type spanData struct {
+	parentID int64
+	data     jsonData
+	sdk      jsonSDKData
+	custom   jsonCustomData
+	http     jsonHTTPData
+	postgres jsonPostgresData
+	rpc      jsonRPCData
+}
+
+var spanDataPool = sync.Pool{
+	New: func() interface{} {
+		return new(spanData)
+	},
+}
+
+// releaseSpanData returns the data of pooled spans to spanDataPool. The spans must not be used afterwards.
+func releaseSpanData(spans []jsonSpan) {
+	for i := range spans {
+		if sd := spans[i].pooled; sd != nil {
+			spans[i].pooled, spans[i].Data, spans[i].ParentID = nil, nil, nil
+
+			*sd = spanData{}
+			spanDataPool.Put(sd)
+		}
+	}
 }
 
 type jsonData struct {
-	Service string       `json:"service,omitempty"`
-	SDK     *jsonSDKData `json:"sdk"`
+	Service  string            `json:"service,omitempty"`
+	SDK      *jsonSDKData      `json:"sdk,omitempty"`
+	HTTP     *jsonHTTPData     `json:"http,omitempty"`
+	Postgres *jsonPostgresData `json:"pg,omitempty"`
+	RPC      *jsonRPCData      `json:"rpc,omitempty"`
+	// Custom holds the tags, logs and baggage of registered spans that are not
+	// part of their registered data
+	Custom *jsonCustomData `json:"custom,omitempty"`
 }
 
 type jsonCustomData struct {
-	Tags    ot.Tags                           `json:"tags,omitempty"`
-	Logs    map[uint64]map[string]interface{} `json:"logs,omitempty"`
-	Baggage map[string]string                 `json:"baggage,omitempty"`
+	Tags    ot.Tags           `json:"tags,omitempty"`
+	Logs    []jsonLogRecord   `json:"logs,omitempty"`
+	Baggage map[string]string `json:"baggage,omitempty"`
+}
+
+// jsonLogRecord is a single span log record. Records are kept in the order they were
+// logged, so that the ones made within the same millisecond do not overwrite each other.
+type jsonLogRecord struct {
+	// Timestamp in milliseconds
+	Timestamp uint64                 `json:"ts"`
+	Fields    map[string]interface{} `json:"fields"`
 }
 
 type jsonSDKData struct {
@@ -0,0 +1,256 @@
+package instana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLifecycleEventsInterval is the default minimum interval between two lifecycle events of the same kind
+const DefaultLifecycleEventsInterval = time.Minute
+
+// Lifecycle event kinds
+const (
+	lifecycleStartup   = "startup"
+	lifecycleShutdown  = "shutdown"
+	lifecyclePanic     = "panic"
+	lifecycleAgentLost = "agent_lost"
+)
+
+// LifecycleEventsOptions configures the events the sensor sends automatically on process startup
+// and shutdown, recovered panics and lost agent connection. These events are disabled by default.
+type LifecycleEventsOptions struct {
+	// Enabled turns the lifecycle events on
+	Enabled bool
+	// StartupSeverity defaults to SeverityChange
+	StartupSeverity Severity
+	// ShutdownSeverity defaults to SeverityChange
+	ShutdownSeverity Severity
+	// PanicSeverity defaults to SeverityCritical
+	PanicSeverity Severity
+	// AgentLostSeverity defaults to SeverityWarning
+	AgentLostSeverity Severity
+	// MinInterval is the minimum interval between two events of the same kind. The events sent
+	// within this interval after the previous one are dropped. Defaults to 1 minute.
+	MinInterval time.Duration
+}
+
+// lifecycleEvents sends the lifecycle events for the service. Events are held until the
+// agent is ready to accept them. All methods are no-op for a nil *lifecycleEvents, which is
+// used when lifecycle events are disabled.
+type lifecycleEvents struct {
+	agent   *agentS
+	service string
+	opts    LifecycleEventsOptions
+
+	mu      sync.Mutex
+	last    map[string]time.Time
+	pending []*EventData
+	dropped uint64
+}
+
+func newLifecycleEvents(opts LifecycleEventsOptions, service string, agent *agentS) *lifecycleEvents {
+	if !opts.Enabled {
+		return nil
+	}
+
+	if opts.StartupSeverity == 0 {
+		opts.StartupSeverity = SeverityChange
+	}
+
+	if opts.ShutdownSeverity == 0 {
+		opts.ShutdownSeverity = SeverityChange
+	}
+
+	if opts.PanicSeverity == 0 {
+		opts.PanicSeverity = SeverityCritical
+	}
+
+	if opts.AgentLostSeverity == 0 {
+		opts.AgentLostSeverity = SeverityWarning
+	}
+
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = DefaultLifecycleEventsInterval
+	}
+
+	return &lifecycleEvents{
+		agent:   agent,
+		service: service,
+		opts:    opts,
+		last:    make(map[string]time.Time),
+	}
+}
+
+// started sends the process startup event along with the build information
+func (r *lifecycleEvents) started() {
+	if r == nil {
+		return
+	}
+
+	e := r.newEvent(lifecycleStartup, "Service started", r.opts.StartupSeverity).
+		WithText(fmt.Sprintf("%s (pid %d) started", r.service, os.Getpid())).
+		WithField("go_version", runtime.Version())
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		build := newBuildInfo(bi)
+
+		if build.Version != "" {
+			e = e.WithField("version", build.Version)
+		}
+
+		if build.Revision != "" {
+			e = e.WithField("revision", build.Revision)
+		}
+	}
+
+	r.submit(lifecycleStartup, e)
+}
+
+// shuttingDown synchronously sends the graceful shutdown event
+func (r *lifecycleEvents) shuttingDown(ctx context.Context) error {
+	if r == nil || !r.allow(lifecycleShutdown) {
+		return nil
+	}
+
+	e := r.newEvent(lifecycleShutdown, "Service stopped", r.opts.ShutdownSeverity).
+		WithText(fmt.Sprintf("%s (pid %d) is shutting down", r.service, os.Getpid()))
+
+	data := e.Data()
+
+	return deliverEvent(ctx, r.agent.clock(), r.agent.transport, &data)
+}
+
+// panicked sends an event about the panic recovered while handling an instrumented call
+func (r *lifecycleEvents) panicked(operation string, v interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.submit(lifecyclePanic, r.newEvent(lifecyclePanic, "Panic in "+operation, r.opts.PanicSeverity).
+		WithText(fmt.Sprint(v)))
+}
+
+// agentLost sends an event when the sensor loses the connection to the host agent. The event
+// is held until the connection is established again.
+func (r *lifecycleEvents) agentLost() {
+	if r == nil || !r.allow(lifecycleAgentLost) {
+		return
+	}
+
+	data := r.newEvent(lifecycleAgentLost, "Lost connection to the host agent", r.opts.AgentLostSeverity).
+		WithText(fmt.Sprintf("%s (pid %d) is reconnecting to the host agent", r.service, os.Getpid())).
+		Data()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hold(&data)
+}
+
+// ready enqueues the events held while the agent was not ready for delivery
+func (r *lifecycleEvents) ready() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, data := range r.pending {
+		r.agent.events.submit(data)
+	}
+	r.pending = nil
+}
+
+func (r *lifecycleEvents) newEvent(kind, title string, sev Severity) Event {
+	return NewEvent(title).
+		WithSeverity(sev).
+		WithPlugin(ServicePlugin).
+		WithEntityID(r.service).
+		WithHost(ServiceHost).
+		WithField("kind", kind).
+		WithField("pid", strconv.Itoa(os.Getpid()))
+}
+
+// submit enqueues the event for asynchronous delivery unless it is rate limited. If the agent
+// is not ready yet, the event is held until it is.
+func (r *lifecycleEvents) submit(kind string, e Event) {
+	if !r.allow(kind) {
+		return
+	}
+
+	data := e.Data()
+
+	// the FSM enters the ready state before the held events are released, so checking it
+	// under the lock guarantees that the event is either sent or released later
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.agent.canSend() {
+		r.agent.events.submit(&data)
+		return
+	}
+
+	r.hold(&data)
+}
+
+// hold keeps the event until the agent is ready. The caller must hold r.mu.
+func (r *lifecycleEvents) hold(data *EventData) {
+	if len(r.pending) >= eventsQueueSize {
+		r.dropped++
+		log.debug("too many lifecycle events waiting for the agent, total dropped:", r.dropped)
+
+		return
+	}
+
+	r.pending = append(r.pending, data)
+}
+
+// allow returns whether an event of given kind can be sent now, and if so records the time
+func (r *lifecycleEvents) allow(kind string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.agent.clock().Now()
+	if last, ok := r.last[kind]; ok && now.Sub(last) < r.opts.MinInterval {
+		r.dropped++
+		log.debug("rate limited", kind, "lifecycle event, total dropped:", r.dropped)
+
+		return false
+	}
+
+	r.last[kind] = now
+
+	return true
+}
+
+// Shutdown reports the graceful shutdown of the process to Instana if lifecycle events are enabled
+// and sends all queued spans and metrics. It should be called right before the process exits.
+func Shutdown(ctx context.Context) error {
+	if sensor == nil {
+		return errors.New("instana: sensor is not initialized")
+	}
+
+	var errs []string
+	if err := sensor.agent.lifecycle.shuttingDown(ctx); err != nil {
+		errs = append(errs, "instana: failed to send shutdown event: "+err.Error())
+	}
+
+	if err := Flush(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+
+	return nil
+}
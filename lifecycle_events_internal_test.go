@@ -0,0 +1,269 @@
+package instana
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/instana/go-sensor/fakeagent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLifecycleTestAgent returns an agent that is always ready to send data, the same
+// way it is in serverless mode
+func newLifecycleTestAgent(tr *InMemoryTransport) *agentS {
+	agent := &agentS{transport: tr, endpoint: &serverlessEndpoint{}}
+	agent.events = newSender("event", eventsQueueSize, agent.sendEvent)
+
+	return agent
+}
+
+func TestLifecycleEventsDisabled(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+	lc := newLifecycleEvents(LifecycleEventsOptions{}, "test", newLifecycleTestAgent(tr))
+	require.Nil(t, lc)
+
+	// all methods are safe to call on a nil receiver
+	lc.started()
+	lc.panicked("test", "boom")
+	lc.agentLost()
+	assert.NoError(t, lc.shuttingDown(context.Background()))
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, tr.Events())
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+	lc := newLifecycleEvents(LifecycleEventsOptions{
+		Enabled:       true,
+		PanicSeverity: SeverityWarning,
+	}, "test-service", newLifecycleTestAgent(tr))
+
+	lc.started()
+	require.Eventually(t, func() bool { return len(tr.Events()) == 1 }, time.Second, 10*time.Millisecond)
+
+	e := recordedEvent(t, tr, 0)
+	assert.Equal(t, "Service started", e.Title)
+	assert.Equal(t, int(SeverityChange), e.Severity)
+	assert.Equal(t, ServicePlugin, e.Plugin)
+	assert.Equal(t, "test-service", e.ID)
+	assert.Equal(t, lifecycleStartup, e.Fields["kind"])
+	assert.NotEmpty(t, e.Fields["go_version"])
+
+	lc.panicked("GET /", "boom")
+	require.Eventually(t, func() bool { return len(tr.Events()) == 2 }, time.Second, 10*time.Millisecond)
+
+	e = recordedEvent(t, tr, 1)
+	assert.Equal(t, "Panic in GET /", e.Title)
+	assert.Equal(t, "boom", e.Text)
+	assert.Equal(t, int(SeverityWarning), e.Severity)
+
+	// the agent lost event is held until the agent is ready again
+	lc.agentLost()
+	time.Sleep(10 * time.Millisecond)
+	require.Len(t, tr.Events(), 2)
+
+	lc.ready()
+	require.Eventually(t, func() bool { return len(tr.Events()) == 3 }, time.Second, 10*time.Millisecond)
+
+	e = recordedEvent(t, tr, 2)
+	assert.Equal(t, "Lost connection to the host agent", e.Title)
+	assert.Equal(t, int(SeverityWarning), e.Severity)
+
+	// shutdown event is sent synchronously
+	require.NoError(t, lc.shuttingDown(context.Background()))
+	require.Len(t, tr.Events(), 4)
+
+	e = recordedEvent(t, tr, 3)
+	assert.Equal(t, "Service stopped", e.Title)
+	assert.Equal(t, int(SeverityChange), e.Severity)
+}
+
+func TestLifecycleEventsRateLimit(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+	clock := newManualClock(time.Now())
+
+	agent := newLifecycleTestAgent(tr)
+	agent.sensor = &sensorS{options: &Options{Clock: clock}}
+
+	lc := newLifecycleEvents(LifecycleEventsOptions{
+		Enabled:     true,
+		MinInterval: time.Minute,
+	}, "test-service", agent)
+
+	lc.panicked("GET /", "first")
+	lc.panicked("GET /", "second")
+	lc.started()
+
+	require.Eventually(t, func() bool { return len(tr.Events()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "first", recordedEvent(t, tr, 0).Text)
+
+	clock.Advance(time.Minute - time.Millisecond)
+	lc.panicked("GET /", "third")
+
+	clock.Advance(time.Millisecond)
+	lc.panicked("GET /", "fourth")
+	require.Eventually(t, func() bool { return len(tr.Events()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "fourth", recordedEvent(t, tr, 2).Text)
+}
+
+func TestLifecycleEventsAgentLost(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{
+		Service:         "test",
+		MetricsInterval: time.Hour,
+		Transport:       tr,
+		LifecycleEvents: LifecycleEventsOptions{Enabled: true},
+	})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+
+	require.Eventually(t, sensor.agent.canSend, time.Second, 10*time.Millisecond, "agent never became ready")
+
+	sensor.agent.reset()
+
+	require.Eventually(t, func() bool { return len(tr.Events()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, lifecycleAgentLost, recordedEvent(t, tr, 0).Fields["kind"])
+}
+
+func TestLifecycleEventsHeldUntilAgentIsReady(t *testing.T) {
+	InitSensor(&Options{})
+
+	agent := fakeagent.New()
+	defer agent.Close()
+
+	agent.RejectAnnouncements(1)
+
+	clock := newManualClock(time.Now())
+
+	s := &sensorS{serviceName: "test"}
+	s.setOptions(&Options{
+		AgentEndpoint:   agent.URL(),
+		Clock:           clock,
+		LifecycleEvents: LifecycleEventsOptions{Enabled: true},
+	})
+
+	a := s.initAgent()
+	a.lifecycle.started()
+
+	// the startup event is not sent while the announcement is being retried
+	require.Eventually(t, func() bool { return clock.Pending() > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, agent.Events())
+
+	clock.Advance(retryPeriod * time.Millisecond)
+
+	require.Eventually(t, func() bool { return len(agent.Events()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, lifecycleStartup, agent.Events()[0]["fields"].(map[string]interface{})["kind"])
+
+	// the agent lost event is sent once the process is announced again
+	agent.RejectAnnouncements(1)
+	a.reset()
+
+	require.Eventually(t, func() bool { return clock.Pending() > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, a.canSend())
+	assert.Len(t, agent.Events(), 1)
+
+	clock.Advance(retryPeriod * time.Millisecond)
+
+	require.Eventually(t, func() bool { return len(agent.Events()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, lifecycleAgentLost, agent.Events()[1]["fields"].(map[string]interface{})["kind"])
+
+	// both events have been sent only after the process was announced
+	var sequence []string
+	for _, req := range agent.Requests() {
+		switch req.Endpoint {
+		case fakeagent.Discovery:
+			sequence = append(sequence, fmt.Sprintf("discovery %d", req.Status))
+		case fakeagent.Events:
+			sequence = append(sequence, "event")
+		}
+	}
+
+	assert.Equal(t, []string{
+		"discovery 404", "discovery 200", "event",
+		"discovery 404", "discovery 200", "event",
+	}, sequence)
+}
+
+func TestLifecycleEventsPanicInHandler(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{
+		Service:         "test",
+		MetricsInterval: time.Hour,
+		Transport:       tr,
+		LifecycleEvents: LifecycleEventsOptions{Enabled: true},
+	})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+
+	require.Eventually(t, sensor.agent.canSend, time.Second, 10*time.Millisecond, "agent never became ready")
+
+	s := &Sensor{tracer: NewTracerWithEverything(sensor.options, NewTestRecorder())}
+	h := s.TracingHandler("panicking", func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+
+	assert.Panics(t, func() {
+		h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	require.Eventually(t, func() bool { return len(tr.Events()) == 1 }, time.Second, 10*time.Millisecond)
+
+	e := recordedEvent(t, tr, 0)
+	assert.Equal(t, "Panic in panicking", e.Title)
+	assert.Equal(t, "boom", e.Text)
+	assert.Equal(t, int(SeverityCritical), e.Severity)
+}
+
+func TestShutdown(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{
+		Service:         "test",
+		MetricsInterval: time.Hour,
+		Transport:       tr,
+		LifecycleEvents: LifecycleEventsOptions{Enabled: true},
+	})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+	sensor.meter = sensor.initMeter()
+
+	require.Eventually(t, sensor.agent.canSend, time.Second, 10*time.Millisecond, "agent never became ready")
+
+	require.NoError(t, Shutdown(context.Background()))
+
+	require.Len(t, tr.Events(), 1)
+	assert.Equal(t, "Service stopped", recordedEvent(t, tr, 0).Title)
+	assert.Len(t, tr.Metrics(), 1)
+}
@@ -22,26 +22,32 @@ func (r *logS) makeV(prefix string, v ...interface{}) []interface{} {
 	return append([]interface{}{prefix}, v...)
 }
 
+// enabled returns whether the messages of given level should be logged. Nothing is logged until
+// the sensor is initialized, i.e. when spans are created by a standalone tracer.
+func (r *logS) enabled(level int) bool {
+	return r != nil && r.sensor.options.LogLevel >= level
+}
+
 func (r *logS) debug(v ...interface{}) {
-	if r.sensor.options.LogLevel >= Debug {
+	if r.enabled(Debug) {
 		l.Println(r.makeV("DEBUG: instana:", v...)...)
 	}
 }
 
 func (r *logS) info(v ...interface{}) {
-	if r.sensor.options.LogLevel >= Info {
+	if r.enabled(Info) {
 		l.Println(r.makeV("INFO: instana:", v...)...)
 	}
 }
 
 func (r *logS) warn(v ...interface{}) {
-	if r.sensor.options.LogLevel >= Warn {
+	if r.enabled(Warn) {
 		l.Println(r.makeV("WARN: instana:", v...)...)
 	}
 }
 
 func (r *logS) error(v ...interface{}) {
-	if r.sensor.options.LogLevel >= Error {
+	if r.enabled(Error) {
 		l.Println(r.makeV("ERROR: instana:", v...)...)
 	}
 }
@@ -1,27 +1,67 @@
 package instana
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"runtime"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	// SnapshotPeriod is the amount of time in seconds between snapshot reports.
 	SnapshotPeriod = 600
+	// DefaultMetricsInterval is the default interval between two metrics collections.
+	DefaultMetricsInterval = 1 * time.Second
+	// DefaultFullMetricsEvery is the default number of intervals after which the full
+	// metrics payload is sent if Options.DeltaMetrics is enabled.
+	DefaultFullMetricsEvery = 60
 )
 
 // SnapshotS struct to hold snapshot data.
 type SnapshotS struct {
-	Name     string `json:"name"`
-	Version  string `json:"version"`
-	Root     string `json:"goroot"`
-	MaxProcs int    `json:"maxprocs"`
-	Compiler string `json:"compiler"`
-	NumCPU   int    `json:"cpu"`
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Root      string            `json:"goroot"`
+	MaxProcs  int               `json:"maxprocs"`
+	Compiler  string            `json:"compiler"`
+	NumCPU    int               `json:"cpu"`
+	Hostname  string            `json:"hostname,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Build     *BuildInfoS       `json:"build,omitempty"`
+	Container *ContainerS       `json:"container,omitempty"`
+}
+
+// BuildInfoS struct to hold the build information embedded into the binary.
+type BuildInfoS struct {
+	Path         string    `json:"path"`
+	Version      string    `json:"version,omitempty"`
+	Revision     string    `json:"revision,omitempty"`
+	RevisionTime string    `json:"revision_time,omitempty"`
+	Modified     bool      `json:"modified,omitempty"`
+	Dependencies []ModuleS `json:"dependencies,omitempty"`
+}
+
+// ModuleS struct to hold the version of a module dependency.
+type ModuleS struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Replace string `json:"replace,omitempty"`
 }
 
 // MemoryS struct to hold snapshot data.
+//
+// TotalAlloc, Lookups, Mallocs and Frees are reported as the difference
+// to the previous collection, all other values are gauges.
 type MemoryS struct {
 	Alloc         uint64  `json:"alloc"`
 	TotalAlloc    uint64  `json:"total_alloc"`
@@ -35,114 +75,425 @@ type MemoryS struct {
 	HeapInuse     uint64  `json:"heap_in_use"`
 	HeapReleased  uint64  `json:"heap_released"`
 	HeapObjects   uint64  `json:"heap_objects"`
+	StackInuse    uint64  `json:"stack_in_use"`
+	StackSys      uint64  `json:"stack_sys"`
 	PauseTotalNs  uint64  `json:"pause_total_ns"`
 	PauseNs       uint64  `json:"pause_ns"`
 	NumGC         uint32  `json:"num_gc"`
 	GCCPUFraction float64 `json:"gc_cpu_fraction"`
 }
 
+// GCS struct to hold garbage collector metrics aggregated over all
+// cycles that finished since the previous collection.
+type GCS struct {
+	Cycles       uint32 `json:"cycles"`
+	ForcedCycles uint32 `json:"forced_cycles"`
+	PauseCount   int    `json:"pause_count"`
+	PauseMaxNs   uint64 `json:"pause_max_ns"`
+	PauseSumNs   uint64 `json:"pause_sum_ns"`
+	NextGC       uint64 `json:"next_gc"`
+}
+
+// DroppedS struct to hold the number of spans and payloads dropped by the sensor since
+// the previous collection because the agent was not keeping up.
+type DroppedS struct {
+	Spans   uint64 `json:"spans"`
+	Metrics uint64 `json:"metrics"`
+	Events  uint64 `json:"events"`
+}
+
 // MetricsS struct to hold snapshot data.
+//
+// CgoCall is reported as the number of cgo calls since the previous
+// collection. Threads and FDs are only available on systems providing /proc.
 type MetricsS struct {
-	CgoCall   int64    `json:"cgo_call"`
-	Goroutine int      `json:"goroutine"`
-	Memory    *MemoryS `json:"memory"`
+	CgoCall   int64     `json:"cgo_call"`
+	Goroutine int       `json:"goroutine"`
+	Threads   int       `json:"threads,omitempty"`
+	FDs       int       `json:"fds,omitempty"`
+	Memory    *MemoryS  `json:"memory"`
+	GC        *GCS      `json:"gc"`
+	Dropped   *DroppedS `json:"dropped"`
 }
 
 // EntityData struct to hold snapshot data.
 type EntityData struct {
-	PID      int        `json:"pid"`
-	Snapshot *SnapshotS `json:"snapshot,omitempty"`
-	Metrics  *MetricsS  `json:"metrics"`
+	PID      int             `json:"pid"`
+	Snapshot *SnapshotS      `json:"snapshot,omitempty"`
+	Metrics  *MetricsS       `json:"metrics"`
+	Custom   []CustomMetricS `json:"custom,omitempty"`
+}
+
+// entityDataDelta is sent instead of EntityData if Options.DeltaMetrics is enabled
+// and contains only those metrics that changed since the previous payload.
+type entityDataDelta struct {
+	PID      int                    `json:"pid"`
+	Snapshot *SnapshotS             `json:"snapshot,omitempty"`
+	Metrics  map[string]interface{} `json:"metrics,omitempty"`
+	Custom   []CustomMetricS        `json:"custom,omitempty"`
 }
 
 type meterS struct {
+	sync.Mutex
 	sensor            *sensorS
 	numGC             uint32
-	ticker            *time.Ticker
+	numForcedGC       uint32
+	totalAlloc        uint64
+	lookups           uint64
+	mallocs           uint64
+	frees             uint64
+	cgoCall           int64
+	ticker            Ticker
 	snapshotCountdown int
+	snapshotPeriod    int
+	fullCountdown     int
+	forceFull         int32
+	forceSnapshot     int32
+	prevMetrics       map[string]interface{}
+	sender            *sender
+	dropped           DroppedS
 }
 
 func (r *meterS) init() {
-	r.ticker = time.NewTicker(1 * time.Second)
+	// Use the current state as a baseline, so that the first collection
+	// doesn't report everything that happened since the process start
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	r.collectMemoryMetrics(&memStats)
+	r.collectGCMetrics(&memStats)
+	r.cgoCall = runtime.NumCgoCall()
+
+	interval := r.sensor.options.MetricsInterval
+	r.snapshotPeriod = int(SnapshotPeriod * time.Second / interval)
+	if r.snapshotPeriod < 1 {
+		r.snapshotPeriod = 1
+	}
+
+	r.sender = newSender("metrics", metricsQueueSize, r.send)
+
+	r.snapshotCountdown = 1
+	r.ticker = r.sensor.options.Clock.NewTicker(interval)
 	go func() {
-		r.snapshotCountdown = 1
-		for range r.ticker.C {
+		for range r.ticker.C() {
 			if r.sensor.agent.canSend() {
-				r.snapshotCountdown--
-				var s *SnapshotS
-				if r.snapshotCountdown == 0 {
-					r.snapshotCountdown = SnapshotPeriod
-					s = r.collectSnapshot()
-					log.debug("collected snapshot")
-				} else {
-					s = nil
+				if d := r.collect(); d != nil && !r.sender.submit(d) {
+					// the next payload needs to be complete, since this one was never sent
+					atomic.StoreInt32(&r.forceFull, 1)
 				}
-
-				pid, _ := strconv.Atoi(r.sensor.agent.from.PID)
-				d := &EntityData{
-					PID:      pid,
-					Snapshot: s,
-					Metrics:  r.collectMetrics()}
-
-				go r.send(d)
 			}
 		}
 	}()
 }
 
-func (r *meterS) send(d *EntityData) {
-	_, err := r.sensor.agent.request(r.sensor.agent.makeURL(agentDataURL), "POST", d)
+// collect returns the next payload to be sent to the agent. If Options.DeltaMetrics
+// is enabled, this method returns nil if there is nothing new to report.
+func (r *meterS) collect() interface{} {
+	r.Lock()
+	defer r.Unlock()
+
+	if atomic.SwapInt32(&r.forceSnapshot, 0) == 1 {
+		r.snapshotCountdown = 1
+	}
+
+	r.snapshotCountdown--
+	var s *SnapshotS
+	if r.snapshotCountdown == 0 {
+		r.snapshotCountdown = r.snapshotPeriod
+		s = r.collectSnapshot()
+		log.debug("collected snapshot")
+	} else {
+		s = nil
+	}
+
+	pid, _ := strconv.Atoi(r.sensor.agent.from.PID)
+	d := &EntityData{
+		PID:      pid,
+		Snapshot: s,
+		Metrics:  r.collectMetrics(),
+		Custom:   customMetrics.collect()}
+
+	if !r.sensor.options.DeltaMetrics {
+		return d
+	}
+
+	return r.delta(d)
+}
+
+// delta strips all metrics that did not change since the previous payload from d. A full
+// payload is returned every Options.FullMetricsEvery intervals and after a failed delivery.
+func (r *meterS) delta(d *EntityData) interface{} {
+	metrics, err := metricsToMap(d.Metrics)
+	if err != nil {
+		log.debug("failed to compute metrics delta, sending full payload:", err)
+		return d
+	}
+
+	r.fullCountdown--
+	if atomic.SwapInt32(&r.forceFull, 0) == 1 || r.prevMetrics == nil || r.fullCountdown <= 0 {
+		r.fullCountdown = r.sensor.options.FullMetricsEvery
+		r.prevMetrics = metrics
+
+		return d
+	}
+
+	changed := diffMetrics(r.prevMetrics, metrics)
+	r.prevMetrics = metrics
+
+	if len(changed) == 0 && d.Snapshot == nil && len(d.Custom) == 0 {
+		return nil
+	}
+
+	return &entityDataDelta{
+		PID:      d.PID,
+		Snapshot: d.Snapshot,
+		Metrics:  changed,
+		Custom:   d.Custom}
+}
+
+func (r *meterS) send(d interface{}) {
+	err := r.sensor.agent.transport.SendMetrics(context.Background(), d)
 
 	if err != nil {
+		// the agent might have missed the previous payload, so the next one needs to be complete
+		atomic.StoreInt32(&r.forceFull, 1)
 		r.sensor.agent.reset()
 	}
 }
 
-func (r *meterS) collectMemoryMetrics() *MemoryS {
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
+// metricsToMap converts metrics to their JSON object representation. Numbers are kept as json.Number
+// to avoid precision loss for large counters.
+func metricsToMap(m *MetricsS) (map[string]interface{}, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var ret map[string]interface{}
+	if err := dec.Decode(&ret); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// diffMetrics returns the fields of curr that are missing or have a different value in prev.
+// Nested objects are compared field by field.
+func diffMetrics(prev, curr map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for k, v := range curr {
+		prevV, ok := prev[k]
+		if !ok {
+			ret[k] = v
+			continue
+		}
+
+		vm, vIsMap := v.(map[string]interface{})
+		pm, pIsMap := prevV.(map[string]interface{})
+		if vIsMap && pIsMap {
+			if d := diffMetrics(pm, vm); len(d) > 0 {
+				ret[k] = d
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(prevV, v) {
+			ret[k] = v
+		}
+	}
+
+	return ret
+}
+
+func (r *meterS) collectMemoryMetrics(memStats *runtime.MemStats) *MemoryS {
 	ret := &MemoryS{
 		Alloc:         memStats.Alloc,
-		TotalAlloc:    memStats.TotalAlloc,
+		TotalAlloc:    memStats.TotalAlloc - r.totalAlloc,
 		Sys:           memStats.Sys,
-		Lookups:       memStats.Lookups,
-		Mallocs:       memStats.Mallocs,
-		Frees:         memStats.Frees,
+		Lookups:       memStats.Lookups - r.lookups,
+		Mallocs:       memStats.Mallocs - r.mallocs,
+		Frees:         memStats.Frees - r.frees,
 		HeapAlloc:     memStats.HeapAlloc,
 		HeapSys:       memStats.HeapSys,
 		HeapIdle:      memStats.HeapIdle,
 		HeapInuse:     memStats.HeapInuse,
 		HeapReleased:  memStats.HeapReleased,
 		HeapObjects:   memStats.HeapObjects,
+		StackInuse:    memStats.StackInuse,
+		StackSys:      memStats.StackSys,
 		PauseTotalNs:  memStats.PauseTotalNs,
 		NumGC:         memStats.NumGC,
 		GCCPUFraction: memStats.GCCPUFraction}
 
 	if r.numGC < memStats.NumGC {
 		ret.PauseNs = memStats.PauseNs[(memStats.NumGC+255)%256]
-		r.numGC = memStats.NumGC
 	} else {
 		ret.PauseNs = 0
 	}
 
+	r.totalAlloc = memStats.TotalAlloc
+	r.lookups = memStats.Lookups
+	r.mallocs = memStats.Mallocs
+	r.frees = memStats.Frees
+
+	return ret
+}
+
+// collectGCMetrics aggregates the pauses of all GC cycles that finished since the
+// previous call. Since runtime.MemStats only keeps the 256 most recent pauses, older
+// ones are counted as cycles, but are not included into the pause statistics.
+func (r *meterS) collectGCMetrics(memStats *runtime.MemStats) *GCS {
+	ret := &GCS{
+		Cycles:       memStats.NumGC - r.numGC,
+		ForcedCycles: memStats.NumForcedGC - r.numForcedGC,
+		NextGC:       memStats.NextGC}
+
+	from := r.numGC
+	if ret.Cycles > uint32(len(memStats.PauseNs)) {
+		from = memStats.NumGC - uint32(len(memStats.PauseNs))
+	}
+
+	for i := from; i < memStats.NumGC; i++ {
+		pause := memStats.PauseNs[i%uint32(len(memStats.PauseNs))]
+
+		ret.PauseCount++
+		ret.PauseSumNs += pause
+		if pause > ret.PauseMaxNs {
+			ret.PauseMaxNs = pause
+		}
+	}
+
+	r.numGC = memStats.NumGC
+	r.numForcedGC = memStats.NumForcedGC
+
 	return ret
 }
 
 func (r *meterS) collectMetrics() *MetricsS {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	cgoCall := runtime.NumCgoCall()
+	defer func() { r.cgoCall = cgoCall }()
+
 	return &MetricsS{
-		CgoCall:   runtime.NumCgoCall(),
+		CgoCall:   cgoCall - r.cgoCall,
 		Goroutine: runtime.NumGoroutine(),
-		Memory:    r.collectMemoryMetrics()}
+		Threads:   getThreadsCount("/proc/self/status"),
+		FDs:       getOpenFDsCount("/proc/self/fd"),
+		Memory:    r.collectMemoryMetrics(&memStats),
+		GC:        r.collectGCMetrics(&memStats),
+		Dropped:   r.collectDroppedMetrics()}
+}
+
+// collectDroppedMetrics returns the number of spans and payloads dropped since the previous collection
+func (r *meterS) collectDroppedMetrics() *DroppedS {
+	var total DroppedS
+	for _, rec := range r.sensor.getRecorders() {
+		total.Spans += rec.droppedCount()
+	}
+	total.Metrics = r.sender.droppedCount()
+	if r.sensor.agent != nil {
+		total.Events = r.sensor.agent.events.droppedCount()
+	}
+
+	ret := &DroppedS{
+		Spans:   total.Spans - r.dropped.Spans,
+		Metrics: total.Metrics - r.dropped.Metrics,
+		Events:  total.Events - r.dropped.Events,
+	}
+	r.dropped = total
+
+	return ret
+}
+
+// getThreadsCount returns the number of OS threads used by the process as reported
+// in the Threads: field of /proc/<pid>/status or 0 if this information is not available.
+func getThreadsCount(statusFile string) int {
+	b, err := ioutil.ReadFile(statusFile)
+	if err != nil {
+		return 0
+	}
+
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "Threads:") {
+			continue
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Threads:")))
+		if err != nil {
+			log.debug("failed to parse threads count:", err)
+			return 0
+		}
+
+		return n
+	}
+
+	return 0
+}
+
+// getOpenFDsCount returns the number of open file descriptors by counting entries
+// in /proc/<pid>/fd or 0 if this information is not available.
+func getOpenFDsCount(fdDir string) int {
+	entries, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return 0
+	}
+
+	return len(entries)
 }
 
 func (r *meterS) collectSnapshot() *SnapshotS {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.debug("failed to get hostname:", err)
+	}
+
+	var build *BuildInfoS
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		build = newBuildInfo(bi)
+	}
+
 	return &SnapshotS{
-		Name:     r.sensor.serviceName,
-		Version:  runtime.Version(),
-		Root:     runtime.GOROOT(),
-		MaxProcs: runtime.GOMAXPROCS(0),
-		Compiler: runtime.Compiler,
-		NumCPU:   runtime.NumCPU()}
+		Name:      r.sensor.serviceName,
+		Version:   runtime.Version(),
+		Root:      runtime.GOROOT(),
+		MaxProcs:  runtime.GOMAXPROCS(0),
+		Compiler:  runtime.Compiler,
+		NumCPU:    runtime.NumCPU(),
+		Hostname:  hostname,
+		Labels:    r.sensor.options.Labels,
+		Build:     build,
+		Container: getContainerInfo("/proc/self/cgroup")}
+}
+
+func newBuildInfo(bi *debug.BuildInfo) *BuildInfoS {
+	ret := &BuildInfoS{
+		Path:    bi.Main.Path,
+		Version: bi.Main.Version,
+	}
+
+	// VCS information is only available for binaries built with Go 1.18+
+	setVCSInfo(ret, bi)
+
+	for _, dep := range bi.Deps {
+		m := ModuleS{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			m.Replace = dep.Replace.Path
+			if dep.Replace.Version != "" {
+				m.Replace += "@" + dep.Replace.Version
+			}
+		}
+
+		ret.Dependencies = append(ret.Dependencies, m)
+	}
+
+	return ret
 }
 
 func (r *sensorS) initMeter() *meterS {
@@ -0,0 +1,210 @@
+package instana
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGCMetrics(t *testing.T) {
+	m := &meterS{numGC: 10, numForcedGC: 1}
+
+	var memStats runtime.MemStats
+	memStats.NumGC = 13
+	memStats.NumForcedGC = 2
+	memStats.NextGC = 4096
+	memStats.PauseNs[10] = 100
+	memStats.PauseNs[11] = 300
+	memStats.PauseNs[12] = 200
+
+	gc := m.collectGCMetrics(&memStats)
+	assert.Equal(t, &GCS{
+		Cycles:       3,
+		ForcedCycles: 1,
+		PauseCount:   3,
+		PauseMaxNs:   300,
+		PauseSumNs:   600,
+		NextGC:       4096,
+	}, gc)
+
+	// No GC cycles since the previous collection
+	gc = m.collectGCMetrics(&memStats)
+	assert.Equal(t, uint32(0), gc.Cycles)
+	assert.Equal(t, 0, gc.PauseCount)
+	assert.Equal(t, uint64(0), gc.PauseSumNs)
+}
+
+func TestCollectGCMetricsBufferWrap(t *testing.T) {
+	m := &meterS{}
+
+	var memStats runtime.MemStats
+	memStats.NumGC = 300
+	for i := range memStats.PauseNs {
+		memStats.PauseNs[i] = 1
+	}
+
+	gc := m.collectGCMetrics(&memStats)
+	assert.Equal(t, uint32(300), gc.Cycles)
+	assert.Equal(t, 256, gc.PauseCount)
+	assert.Equal(t, uint64(256), gc.PauseSumNs)
+}
+
+func TestCollectMemoryMetricsDeltas(t *testing.T) {
+	m := &meterS{}
+
+	var memStats runtime.MemStats
+	memStats.Mallocs = 100
+	memStats.Frees = 40
+	memStats.TotalAlloc = 1024
+	memStats.HeapAlloc = 512
+	m.collectMemoryMetrics(&memStats)
+
+	memStats.Mallocs = 150
+	memStats.Frees = 100
+	memStats.TotalAlloc = 2048
+	mem := m.collectMemoryMetrics(&memStats)
+
+	assert.Equal(t, uint64(50), mem.Mallocs)
+	assert.Equal(t, uint64(60), mem.Frees)
+	assert.Equal(t, uint64(1024), mem.TotalAlloc)
+	assert.Equal(t, uint64(512), mem.HeapAlloc)
+}
+
+func TestCollectDroppedMetrics(t *testing.T) {
+	rec := &Recorder{droppedSpans: 5}
+
+	s := &sensorS{agent: &agentS{}}
+	s.addRecorder(rec)
+
+	m := &meterS{sensor: s, sender: &sender{dropped: 2}}
+	assert.Equal(t, &DroppedS{Spans: 5, Metrics: 2}, m.collectDroppedMetrics())
+
+	rec.droppedSpans = 7
+	s.agent.events = &sender{dropped: 1}
+	assert.Equal(t, &DroppedS{Spans: 2, Events: 1}, m.collectDroppedMetrics())
+}
+
+func TestGetThreadsCount(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "getthreadscount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString("Name:\tgo-sensor\nState:\tS (sleeping)\nThreads:\t12\nSigQ:\t0/63704\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 12, getThreadsCount(tmpFile.Name()))
+	assert.Equal(t, 0, getThreadsCount(tmpFile.Name()+".missing"))
+}
+
+func TestGetOpenFDsCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getopenfdscount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"0", "1", "2"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, 3, getOpenFDsCount(dir))
+	assert.Equal(t, 0, getOpenFDsCount(filepath.Join(dir, "missing")))
+}
+
+func TestNewBuildInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Path: "example.com/app", Version: "v1.2.3"},
+		Deps: []*debug.Module{
+			{Path: "github.com/instana/go-sensor", Version: "v1.4.0"},
+			{Path: "github.com/looplab/fsm", Version: "v0.1.0", Replace: &debug.Module{Path: "../fsm"}},
+		},
+	}
+
+	assert.Equal(t, &BuildInfoS{
+		Path:    "example.com/app",
+		Version: "v1.2.3",
+		Dependencies: []ModuleS{
+			{Path: "github.com/instana/go-sensor", Version: "v1.4.0"},
+			{Path: "github.com/looplab/fsm", Version: "v0.1.0", Replace: "../fsm"},
+		},
+	}, newBuildInfo(bi))
+}
+
+func TestDiffMetrics(t *testing.T) {
+	prev := map[string]interface{}{
+		"goroutine": json.Number("10"),
+		"cgo_call":  json.Number("0"),
+		"memory": map[string]interface{}{
+			"alloc":    json.Number("1024"),
+			"heap_sys": json.Number("4096"),
+		},
+	}
+	curr := map[string]interface{}{
+		"goroutine": json.Number("12"),
+		"cgo_call":  json.Number("0"),
+		"threads":   json.Number("8"),
+		"memory": map[string]interface{}{
+			"alloc":    json.Number("2048"),
+			"heap_sys": json.Number("4096"),
+		},
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"goroutine": json.Number("12"),
+		"threads":   json.Number("8"),
+		"memory": map[string]interface{}{
+			"alloc": json.Number("2048"),
+		},
+	}, diffMetrics(prev, curr))
+
+	assert.Empty(t, diffMetrics(curr, curr))
+}
+
+func TestMeterDelta(t *testing.T) {
+	m := &meterS{
+		sensor: &sensorS{options: &Options{DeltaMetrics: true, FullMetricsEvery: 3}},
+	}
+
+	metrics := &MetricsS{Goroutine: 10, Memory: &MemoryS{Alloc: 1024}, GC: &GCS{}}
+
+	// The first payload is always complete
+	d := m.delta(&EntityData{PID: 1, Metrics: metrics})
+	require.IsType(t, &EntityData{}, d)
+
+	// Nothing changed
+	assert.Nil(t, m.delta(&EntityData{PID: 1, Metrics: metrics}))
+
+	// Only changed fields are sent
+	metrics.Goroutine = 12
+	d = m.delta(&EntityData{PID: 1, Metrics: metrics})
+	require.IsType(t, &entityDataDelta{}, d)
+	assert.Equal(t, map[string]interface{}{"goroutine": json.Number("12")}, d.(*entityDataDelta).Metrics)
+
+	// Full payload every FullMetricsEvery intervals
+	assert.IsType(t, &EntityData{}, m.delta(&EntityData{PID: 1, Metrics: metrics}))
+
+	// Full payload after a failed delivery
+	m.forceFull = 1
+	assert.IsType(t, &EntityData{}, m.delta(&EntityData{PID: 1, Metrics: metrics}))
+	assert.Nil(t, m.delta(&EntityData{PID: 1, Metrics: metrics}))
+
+	// Custom metrics are sent even if runtime metrics did not change
+	custom := []CustomMetricS{{Name: "jobs", Type: CounterMetricType, Value: 1}}
+	d = m.delta(&EntityData{PID: 1, Metrics: metrics, Custom: custom})
+	require.IsType(t, &entityDataDelta{}, d)
+	assert.Empty(t, d.(*entityDataDelta).Metrics)
+	assert.Equal(t, custom, d.(*entityDataDelta).Custom)
+}
@@ -1,12 +1,38 @@
 package instana
 
+import (
+	"crypto/tls"
+	"time"
+
+	bt "github.com/opentracing/basictracer-go"
+)
+
 // Options allows the user to configure the to-be-initialized
 // sensor
 type Options struct {
 	Service                     string
 	AgentHost                   string
 	AgentPort                   int
+	AgentEndpoint               string
+	AgentTLSConfig              *tls.Config
 	MaxBufferedSpans            int
 	ForceTransmissionStartingAt int
 	LogLevel                    int
+	MaxCustomMetrics            int
+	Labels                      map[string]string
+	MetricsInterval             time.Duration
+	DeltaMetrics                bool
+	FullMetricsEvery            int
+	Transport                   Transport
+	MaxSpansPerBatch            int
+	MaxBatchBytes               int
+	SpanProcessors              []SpanProcessor
+	NewSpanEventListener        func() func(bt.SpanEvent)
+	MaxLogsPerSpan              int
+	LifecycleEvents             LifecycleEventsOptions
+	ServerTiming                bool
+	TimingAllowOrigin           string
+	Clock                       Clock
+	IDGenerator                 IDGenerator
+	EnableSpanPool              bool
 }
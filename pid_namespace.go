@@ -0,0 +1,180 @@
+package instana
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Methods used to resolve the host PID of the process
+const (
+	pidFromNSpid  = "nspid"
+	pidFromSched  = "sched"
+	pidFromGetpid = "getpid"
+)
+
+// processPIDInfo holds the information the agent needs to match a containerized process
+// to the one it sees in the host PID namespace
+type processPIDInfo struct {
+	// PID is the process ID in the host PID namespace if it could be determined, otherwise
+	// the PID as seen by the process itself
+	PID int
+	// Method is the name of the method the PID has been resolved with
+	Method string
+	// ContainerID is the ID of the container the process is running in, if any
+	ContainerID string
+	// PIDNamespace is the inode number of the process PID namespace
+	PIDNamespace uint64
+}
+
+var (
+	schedPIDRegexp     = regexp.MustCompile(`\((\d+),`)
+	pidNamespaceRegexp = regexp.MustCompile(`^pid:\[(\d+)\]$`)
+	errPIDNotResolved  = errors.New("no PID found")
+)
+
+// resolveProcessPID collects the PID information of the current process using the procfs
+// mounted at procDir, usually /proc. The host PID is taken from the first method that succeeds:
+//
+//  1. NSpid field of /proc/self/status if the process is in a nested PID namespace. This field
+//     is only available since Linux 4.1 and only lists the host PID if procfs is mounted from
+//     the host PID namespace.
+//  2. The first line of /proc/self/sched that used to contain the host PID on older kernels.
+//  3. The PID returned by os.Getpid(), which is the host PID unless the process is containerized.
+//
+// The container ID and the PID namespace inode are reported regardless of the method used, so
+// that the agent can find the process on its own if the host PID could not be determined.
+func resolveProcessPID(procDir string) processPIDInfo {
+	info := processPIDInfo{
+		PID:    os.Getpid(),
+		Method: pidFromGetpid,
+	}
+
+	// the PID as seen from the namespace procfs has been mounted from
+	ownPID := info.PID
+
+	pid, nspid, err := readStatusPIDs(filepath.Join(procDir, "self", "status"))
+	if err == nil {
+		ownPID = pid
+	}
+
+	if len(nspid) > 1 {
+		info.PID, info.Method = nspid[0], pidFromNSpid
+	} else if pid, err := readSchedPID(filepath.Join(procDir, "self", "sched")); err == nil && pid != ownPID {
+		// newer kernels report the namespaced PID in sched, which is of no use
+		info.PID, info.Method = pid, pidFromSched
+	}
+
+	if c := getContainerInfo(filepath.Join(procDir, "self", "cgroup")); c != nil {
+		info.ContainerID = c.ID
+	}
+
+	if ns, err := readPIDNamespace(filepath.Join(procDir, "self", "ns", "pid")); err == nil {
+		info.PIDNamespace = ns
+	}
+
+	return info
+}
+
+// readStatusPIDs returns the PID of the process as seen from the namespace procfs has been
+// mounted from along with the values of the NSpid field of /proc/<pid>/status file, i.e.
+//
+//	Pid:	12345
+//	NSpid:	12345	1
+//
+// The NSpid field lists the process ID in each PID namespace it belongs to, starting from
+// the outermost one visible to the procfs.
+func readStatusPIDs(statusFile string) (int, []int, error) {
+	f, err := os.Open(statusFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var (
+		pid   int
+		nspid []int
+	)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "Pid:":
+			if pid, err = strconv.Atoi(fields[1]); err != nil {
+				return 0, nil, err
+			}
+		case "NSpid:":
+			for _, v := range fields[1:] {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return 0, nil, err
+				}
+
+				nspid = append(nspid, n)
+			}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if pid == 0 {
+		return 0, nil, errPIDNotResolved
+	}
+
+	return pid, nspid, nil
+}
+
+// readSchedPID returns the PID from the first line of /proc/<pid>/sched file, i.e.
+//
+//	app (12345, #threads: 8)
+func readSchedPID(schedFile string) (int, error) {
+	f, err := os.Open(schedFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return 0, err
+		}
+
+		return 0, errPIDNotResolved
+	}
+
+	// the command name may contain parentheses, so the last match is the one we're looking for
+	matches := schedPIDRegexp.FindAllStringSubmatch(s.Text(), -1)
+	if len(matches) == 0 {
+		return 0, errPIDNotResolved
+	}
+
+	return strconv.Atoi(matches[len(matches)-1][1])
+}
+
+// readPIDNamespace returns the inode number of the PID namespace from the /proc/<pid>/ns/pid
+// link, which points to pid:[<inode>]
+func readPIDNamespace(nsLink string) (uint64, error) {
+	link, err := os.Readlink(nsLink)
+	if err != nil {
+		return 0, err
+	}
+
+	match := pidNamespaceRegexp.FindStringSubmatch(link)
+	if match == nil {
+		return 0, errors.New("unexpected PID namespace link " + link)
+	}
+
+	return strconv.ParseUint(match[1], 10, 64)
+}
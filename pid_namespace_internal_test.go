@@ -0,0 +1,101 @@
+package instana
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveProcessPID(t *testing.T) {
+	tests := map[string]processPIDInfo{
+		"docker": {
+			PID:          12345,
+			Method:       pidFromNSpid,
+			ContainerID:  "3f0b6e3c3b5a1c6e6a6d9e1b2c3d4e5f60718293a4b5c6d7e8f90123456789ab",
+			PIDNamespace: 4026532198,
+		},
+		"containerd": {
+			PID:          23456,
+			Method:       pidFromSched,
+			ContainerID:  "0a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcdef",
+			PIDNamespace: 4026532511,
+		},
+		"cgroupv2": {
+			PID:          os.Getpid(),
+			Method:       pidFromGetpid,
+			ContainerID:  "9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a2f1e0d9c8b7a6f5e4d3c2b1a0f9e8d",
+			PIDNamespace: 4026532734,
+		},
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, expected, resolveProcessPID(filepath.Join("testdata", "proc", name)))
+		})
+	}
+}
+
+func TestResolveProcessPIDNoProcfs(t *testing.T) {
+	assert.Equal(t, processPIDInfo{
+		PID:    os.Getpid(),
+		Method: pidFromGetpid,
+	}, resolveProcessPID("/nonexistent/proc"))
+}
+
+func TestReadSchedPID(t *testing.T) {
+	tests := map[string]struct {
+		in       string
+		expected int
+	}{
+		"plain command":            {"app (12345, #threads: 8)\n", 12345},
+		"command with parentheses": {"app (v2) (12345, #threads: 8)\n", 12345},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			fName := writeTempFile(t, test.in)
+			defer os.Remove(fName)
+
+			pid, err := readSchedPID(fName)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, pid)
+		})
+	}
+
+	for name, in := range map[string]string{
+		"empty file":     "",
+		"unknown format": "app 12345\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			fName := writeTempFile(t, in)
+			defer os.Remove(fName)
+
+			_, err := readSchedPID(fName)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestReadPIDNamespace(t *testing.T) {
+	ns, err := readPIDNamespace(filepath.Join("testdata", "proc", "docker", "self", "ns", "pid"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 4026532198, ns)
+
+	_, err = readPIDNamespace(filepath.Join("testdata", "proc", "docker", "self", "status"))
+	assert.Error(t, err)
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "instana")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+
+	return f.Name()
+}
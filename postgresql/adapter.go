@@ -2,7 +2,6 @@ package postgresql
 
 import (
 	"context"
-	"fmt"
 	instana "github.com/instana/go-sensor"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -55,12 +54,19 @@ func (p *Pool) Query(req *http.Request, ctx context.Context, query string, args
 		)
 	}
 
-	span.SetTag(string(ext.SpanKind), string(ext.SpanKindRPCClientEnum))
-	span.SetTag(string(ext.DBType), "postgres")
-	span.SetTag(string(ext.DBInstance), fmt.Sprintf("%s:%d", p.config.ConnConfig.Host, p.config.ConnConfig.Port))
-	span.SetTag(string(ext.DBUser), p.config.ConnConfig.User)
-	span.SetTag(string(ext.DBStatement), query)
+	tagQuerySpan(span, p.config.ConnConfig, query)
 	defer span.Finish()
 
 	return p.pool.Query(ctx, query, args...)
 }
+
+// tagQuerySpan sets the tags used by Instana to report the span as a PostgreSQL query
+func tagQuerySpan(span ot.Span, config *pgx.ConnConfig, query string) {
+	span.SetTag(string(ext.SpanKind), string(ext.SpanKindRPCClientEnum))
+	span.SetTag(string(ext.DBType), "postgres")
+	span.SetTag(string(ext.PeerHostname), config.Host)
+	span.SetTag(string(ext.PeerPort), config.Port)
+	span.SetTag(string(ext.DBInstance), config.Database)
+	span.SetTag(string(ext.DBUser), config.User)
+	span.SetTag(string(ext.DBStatement), query)
+}
@@ -0,0 +1,35 @@
+package postgresql
+
+import (
+	"testing"
+
+	instana "github.com/instana/go-sensor"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagQuerySpan(t *testing.T) {
+	config, err := pgx.ParseConfig("postgres://app@db.example.com:5433/orders")
+	require.NoError(t, err)
+
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	span := tracer.StartSpan("SELECT * FROM orders")
+	tagQuerySpan(span, config, "SELECT * FROM orders")
+	span.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	assert.Equal(t, "postgres", spans[0].Name)
+	require.NotNil(t, spans[0].Data.Postgres)
+
+	pg := spans[0].Data.Postgres
+	assert.Equal(t, "db.example.com", pg.Host)
+	assert.Equal(t, "5433", pg.Port)
+	assert.Equal(t, "orders", pg.DB)
+	assert.Equal(t, "app", pg.User)
+	assert.Equal(t, "SELECT * FROM orders", pg.Stmt)
+}
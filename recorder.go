@@ -1,8 +1,12 @@
 package instana
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	ot "github.com/opentracing/opentracing-go"
 )
 
 // A SpanRecorder handles all of the `RawSpan` data generated via an
@@ -13,12 +17,49 @@ type SpanRecorder interface {
 	RecordSpan(span *spanS)
 }
 
+// FinishedSpan is a snapshot of a finished span passed to SpanRecorderFunc
+type FinishedSpan struct {
+	TraceID int64
+	SpanID  int64
+	// ParentID is 0 for the root span of a trace
+	ParentID  int64
+	Operation string
+	// Kind is either "entry", "exit" or "intermediate"
+	Kind     string
+	Start    time.Time
+	Duration time.Duration
+	Tags     ot.Tags
+	Logs     []ot.LogRecord
+	Baggage  map[string]string
+	// Error is set if the span has been tagged with "error" or has an error logged
+	Error      bool
+	ErrorCount int
+}
+
+// SpanRecorderFunc is an adapter to use a function as a SpanRecorder. The function receives
+// a copy of the span data that is safe to retain and modify.
+type SpanRecorderFunc func(sp FinishedSpan)
+
+// RecordSpan calls f with the snapshot of the finished span
+func (f SpanRecorderFunc) RecordSpan(span *spanS) {
+	f(span.finished())
+}
+
 // Recorder accepts spans, processes and queues them
 // for delivery to the backend.
 type Recorder struct {
 	sync.RWMutex
-	spans    []jsonSpan
-	testMode bool
+	spans        []jsonSpan
+	testMode     bool
+	flushes      trigger
+	flushReqs    chan flushRequest
+	droppedSpans uint64
+}
+
+// flushRequest asks the recorder worker to send the queued spans and report the result
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
 }
 
 // NewRecorder Establish a Recorder span recorder
@@ -43,11 +84,32 @@ func (r *Recorder) init() {
 		return
 	}
 
-	ticker := time.NewTicker(1 * time.Second)
+	clock := SystemClock
+	if sensor != nil {
+		sensor.addRecorder(r)
+		clock = sensor.options.Clock
+	}
+
+	// All flush requests are handled by a single goroutine, so that there is at most one
+	// request to the agent in flight. Requests made in the meantime are coalesced.
+	r.flushes = newTrigger()
+	r.flushReqs = make(chan flushRequest)
 	go func() {
-		for range ticker.C {
-			if sensor.agent.canSend() {
+		for {
+			select {
+			case <-r.flushes:
 				r.send()
+			case req := <-r.flushReqs:
+				req.done <- r.sendContext(req.ctx)
+			}
+		}
+	}()
+
+	ticker := clock.NewTicker(1 * time.Second)
+	go func() {
+		for range ticker.C() {
+			if sensor.agent.canSend() {
+				r.flushes.fire()
 			}
 		}
 	}()
@@ -62,32 +124,22 @@ func (r *Recorder) RecordSpan(span *spanS) {
 		return
 	}
 
-	var data = &jsonData{}
-	kindTag := span.getSpanKindTag()
-
-	data.SDK = &jsonSDKData{
-		Name:   span.Operation,
-		Type:   kindTag,
-		Custom: &jsonCustomData{Tags: span.Tags, Logs: span.collectLogs()}}
-
-	baggage := make(map[string]string)
-	span.context.ForeachBaggageItem(func(k string, v string) bool {
-		baggage[k] = v
-
-		return true
-	})
-
-	if len(baggage) > 0 {
-		data.SDK.Custom.Baggage = baggage
+	var sd, pooled *spanData
+	if span.tracer.options.EnableSpanPool {
+		sd = spanDataPool.Get().(*spanData)
+		pooled = sd
+	} else {
+		sd = new(spanData)
 	}
 
-	data.Service = sensor.serviceName
+	name := span.jsonData(sd)
+	sd.data.Service = sensor.serviceName
 
+	// the span itself can be reused once finished, so the parent ID is copied
 	var parentID *int64
-	if span.ParentSpanID == 0 {
-		parentID = nil
-	} else {
-		parentID = &span.ParentSpanID
+	if span.ParentSpanID != 0 {
+		sd.parentID = span.ParentSpanID
+		parentID = &sd.parentID
 	}
 
 	r.Lock()
@@ -95,6 +147,8 @@ func (r *Recorder) RecordSpan(span *spanS) {
 
 	if len(r.spans) == sensor.options.MaxBufferedSpans {
 		r.spans = r.spans[1:]
+		n := atomic.AddUint64(&r.droppedSpans, 1)
+		log.debug("span buffer is full, dropped the oldest span, total dropped:", n)
 	}
 
 	r.spans = append(r.spans, jsonSpan{
@@ -103,13 +157,14 @@ func (r *Recorder) RecordSpan(span *spanS) {
 		SpanID:    span.context.SpanID,
 		Timestamp: uint64(span.Start.UnixNano()) / uint64(time.Millisecond),
 		Duration:  uint64(span.Duration) / uint64(time.Millisecond),
-		Name:      "sdk",
+		Name:      name,
 		Error:     span.Error,
 		Ec:        span.Ec,
 		Lang:      "go",
 		From:      sensor.agent.from,
 		Kind:      span.getSpanKindInt(),
-		Data:      data})
+		Data:      &sd.data,
+		pooled:    pooled})
 
 	if r.testMode || !sensor.agent.canSend() {
 		return
@@ -117,7 +172,7 @@ func (r *Recorder) RecordSpan(span *spanS) {
 
 	if len(r.spans) >= sensor.options.ForceTransmissionStartingAt {
 		log.debug("Forcing spans to agent.  Count:", len(r.spans))
-		go r.send()
+		r.flushes.fire()
 	}
 }
 
@@ -161,16 +216,61 @@ func (r *Recorder) clearQueuedSpans() {
 	}
 }
 
-// Retrieve the queued spans and post them to the host agent asynchronously.
+// droppedCount returns the number of spans dropped because the span buffer was full
+func (r *Recorder) droppedCount() uint64 {
+	return atomic.LoadUint64(&r.droppedSpans)
+}
+
+// Retrieve the queued spans and post them to the host agent.
 func (r *Recorder) send() {
+	if err := r.sendContext(context.Background()); err != nil {
+		log.debug("Posting traces failed in send(): ", err)
+		sensor.agent.reset()
+	}
+}
+
+func (r *Recorder) sendContext(ctx context.Context) error {
 	spansToSend := r.GetQueuedSpans()
-	if len(spansToSend) > 0 {
-		go func() {
-			_, err := sensor.agent.request(sensor.agent.makeURL(agentTracesURL), "POST", spansToSend)
-			if err != nil {
-				log.debug("Posting traces failed in send(): ", err)
-				sensor.agent.reset()
-			}
-		}()
+	if len(spansToSend) == 0 {
+		return nil
+	}
+
+	return sensor.agent.sendQueuedSpans(ctx, spansToSend)
+}
+
+// flush sends the queued spans and returns once they are delivered. For recorders running a
+// background worker, the request is handled by the worker after the batch it is currently
+// sending, if any.
+func (r *Recorder) flush(ctx context.Context) error {
+	if r.flushReqs == nil {
+		return r.sendContext(ctx)
 	}
+
+	req := flushRequest{ctx: ctx, done: make(chan error, 1)}
+
+	select {
+	case r.flushReqs <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendQueuedSpans sends the spans taken from a recorder queue. The agent transport, which is also used
+// in serverless mode, encodes the payload before returning, so the data of pooled spans can be reused
+// afterwards. Custom transports are allowed to keep the spans, so their data is left to the GC.
+func (r *agentS) sendQueuedSpans(ctx context.Context, spans []jsonSpan) error {
+	err := r.transport.SendSpans(ctx, spans)
+
+	if _, ok := r.transport.(*agentTransport); ok {
+		releaseSpanData(spans)
+	}
+
+	return err
 }
@@ -1,10 +1,19 @@
 package instana
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 
+	"github.com/instana/go-sensor/fakeagent"
+	ot "github.com/opentracing/opentracing-go"
 	ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSpanKind(t *testing.T) {
@@ -129,3 +138,111 @@ func TestGetHostName(t *testing.T) {
 	hostname := span.(*spanS).getHostName()
 	assert.True(t, len(hostname) > 0, "must return a valid string value")
 }
+
+func TestAgentSendQueuedSpans_ReleasesPooledData(t *testing.T) {
+	InitSensor(&Options{})
+
+	fa := fakeagent.New()
+	defer fa.Close()
+
+	agent := &agentS{
+		sensor: &sensorS{options: &Options{
+			AgentPort:        fa.Port(),
+			MaxSpansPerBatch: 10,
+			MaxBatchBytes:    DefaultMaxBatchBytes,
+		}},
+		from:   &fromS{PID: strconv.Itoa(fakeagent.DefaultPID)},
+		host:   fa.Host(),
+		client: http.DefaultClient,
+	}
+	agent.transport = &agentTransport{agent: agent}
+
+	// the fake agent only accepts data from announced processes
+	require.NoError(t, agent.transport.Announce(context.Background(), map[string]interface{}{}, &agentResponse{}))
+
+	recorder := NewTestRecorder()
+	tracer := NewTracerWithEverything(&Options{EnableSpanPool: true}, recorder)
+
+	parent := tracer.StartSpan("parent")
+	tracer.StartSpan("child", ot.ChildOf(parent.Context())).Finish()
+	parent.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 2)
+	require.NotNil(t, spans[0].pooled)
+
+	require.NoError(t, agent.sendQueuedSpans(context.Background(), spans))
+
+	sent := fa.Spans()
+	require.Len(t, sent, 2)
+	assert.Equal(t, "sdk", sent[0]["n"])
+	assert.NotNil(t, sent[0]["p"])
+
+	for _, sp := range spans {
+		assert.Nil(t, sp.pooled)
+		assert.Nil(t, sp.Data)
+	}
+}
+
+func TestAgentSendQueuedSpans_CustomTransport(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+	agent := &agentS{transport: tr}
+
+	recorder := NewTestRecorder()
+	tracer := NewTracerWithEverything(&Options{EnableSpanPool: true}, recorder)
+	tracer.StartSpan("test").Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.NoError(t, agent.sendQueuedSpans(context.Background(), spans))
+
+	// custom transports are allowed to keep the payload, so the data is not reused
+	require.Len(t, tr.Spans(), 1)
+	assert.NotNil(t, spans[0].Data)
+	assert.Equal(t, "test", spans[0].Data.SDK.Name)
+}
+
+func benchmarkRecordAndSendSpans(b *testing.B, enableSpanPool bool) {
+	InitSensor(&Options{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(b, srv, &Options{
+		MaxSpansPerBatch: DefaultMaxSpansPerBatch,
+		MaxBatchBytes:    DefaultMaxBatchBytes,
+	})
+	agent.transport = &agentTransport{agent: agent}
+
+	recorder := NewTestRecorder()
+	tracer := NewTracerWithEverything(&Options{EnableSpanPool: enableSpanPool}, recorder)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		parent := tracer.StartSpan("parent")
+		child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+		child.SetTag("key", "value")
+		child.Finish()
+		parent.Finish()
+
+		// the span data is returned to the pool only once the spans are sent
+		if i%100 == 99 {
+			if err := agent.sendQueuedSpans(context.Background(), recorder.GetQueuedSpans()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRecordAndSendSpans(b *testing.B) {
+	benchmarkRecordAndSendSpans(b, false)
+}
+
+func BenchmarkRecordAndSendSpans_Pooled(b *testing.B) {
+	benchmarkRecordAndSendSpans(b, true)
+}
@@ -0,0 +1,269 @@
+package instana
+
+import (
+	"fmt"
+	"strings"
+
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// Registered span names recognized by the Instana backend. Spans that cannot be mapped
+// onto any of them are reported as SDK spans.
+const (
+	sdkSpanName       = "sdk"
+	httpSpanName      = "g.http"
+	postgresSpanName  = "postgres"
+	rpcServerSpanName = "rpc-server"
+	rpcClientSpanName = "rpc-client"
+)
+
+type jsonHTTPData struct {
+	Host    string            `json:"host,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Params  string            `json:"params,omitempty"`
+	Headers map[string]string `json:"header,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+type jsonPostgresData struct {
+	Host  string `json:"host,omitempty"`
+	Port  string `json:"port,omitempty"`
+	User  string `json:"user,omitempty"`
+	DB    string `json:"db,omitempty"`
+	Stmt  string `json:"stmt,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type jsonRPCData struct {
+	Host   string `json:"host,omitempty"`
+	Port   string `json:"port,omitempty"`
+	Call   string `json:"call,omitempty"`
+	Flavor string `json:"flavor,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// jsonData fills sd.data with the data to report based on the well-known OpenTracing tags set on
+// the span and returns the span name. The HTTP tags of entry and exit spans take precedence over the
+// database ones, while the spans having only the peer.* tags set are considered to be RPC calls. Tags
+// that are not part of the registered span data are reported along with logs and baggage in the
+// custom section.
+func (r *spanS) jsonData(sd *spanData) string {
+	kind := r.getSpanKindInt()
+
+	switch {
+	case kind != 3 && (r.hasTag(string(ext.HTTPUrl)) || r.hasTag(string(ext.HTTPMethod))):
+		sd.http = r.httpData()
+		sd.data.HTTP = &sd.http
+		r.registeredCustomData(sd, isHTTPTag)
+
+		return httpSpanName
+	case isPostgres(r.getStringTag(string(ext.DBType))):
+		sd.postgres = r.postgresData()
+		sd.data.Postgres = &sd.postgres
+		r.registeredCustomData(sd, isPostgresTag)
+
+		return postgresSpanName
+	case kind == 1 && r.hasPeerTags():
+		sd.rpc = r.rpcData()
+		sd.data.RPC = &sd.rpc
+		r.registeredCustomData(sd, isRPCTag)
+
+		return rpcServerSpanName
+	case kind == 2 && r.hasPeerTags():
+		sd.rpc = r.rpcData()
+		sd.data.RPC = &sd.rpc
+		r.registeredCustomData(sd, isRPCTag)
+
+		return rpcClientSpanName
+	default:
+		sd.custom = r.customData(nil)
+		sd.sdk = jsonSDKData{
+			Name:   r.Operation,
+			Type:   r.getSpanKindTag(),
+			Custom: &sd.custom,
+		}
+		sd.data.SDK = &sd.sdk
+
+		return sdkSpanName
+	}
+}
+
+// registeredCustomData sets the custom section of a registered span to the tags not reported as
+// part of the registered span data, logs and baggage, if there are any
+func (r *spanS) registeredCustomData(sd *spanData, registered func(tag string) bool) {
+	sd.custom = r.customData(registered)
+	if len(sd.custom.Tags) > 0 || len(sd.custom.Logs) > 0 || len(sd.custom.Baggage) > 0 {
+		sd.data.Custom = &sd.custom
+	}
+}
+
+func (r *spanS) httpData() jsonHTTPData {
+	data := jsonHTTPData{
+		Host:   r.getStringTag(string(ext.PeerHostname)),
+		Status: intValue(r.Tags[string(ext.HTTPStatusCode)]),
+		Method: r.getStringTag(string(ext.HTTPMethod)),
+		URL:    r.getStringTag(string(ext.HTTPUrl)),
+		Params: r.getStringTag(httpParamsTag),
+		Error:  r.errorMessage(),
+	}
+
+	for k, v := range r.Tags {
+		if !strings.HasPrefix(k, httpHeaderTagPrefix) {
+			continue
+		}
+
+		if data.Headers == nil {
+			data.Headers = make(map[string]string)
+		}
+		data.Headers[strings.TrimPrefix(k, httpHeaderTagPrefix)] = fmt.Sprint(v)
+	}
+
+	return data
+}
+
+func (r *spanS) postgresData() jsonPostgresData {
+	return jsonPostgresData{
+		Host:  r.getStringTag(string(ext.PeerHostname)),
+		Port:  r.getStringTag(string(ext.PeerPort)),
+		User:  r.getStringTag(string(ext.DBUser)),
+		DB:    r.getStringTag(string(ext.DBInstance)),
+		Stmt:  r.getStringTag(string(ext.DBStatement)),
+		Error: r.errorMessage(),
+	}
+}
+
+func (r *spanS) rpcData() jsonRPCData {
+	host := r.getStringTag(string(ext.PeerHostname))
+	if host == "" {
+		host = r.getStringTag(string(ext.PeerHostIPv4))
+	}
+
+	return jsonRPCData{
+		Host:   host,
+		Port:   r.getStringTag(string(ext.PeerPort)),
+		Call:   r.Operation,
+		Flavor: r.getStringTag(string(ext.Component)),
+		Error:  r.errorMessage(),
+	}
+}
+
+// customData returns the custom span data omitting the tags for which registered returns true
+func (r *spanS) customData(registered func(tag string) bool) jsonCustomData {
+	data := jsonCustomData{Tags: r.Tags, Logs: r.collectLogs()}
+
+	if registered != nil {
+		data.Tags = nil
+		for k, v := range r.Tags {
+			if registered(k) {
+				continue
+			}
+
+			if data.Tags == nil {
+				data.Tags = make(ot.Tags)
+			}
+			data.Tags[k] = v
+		}
+	}
+
+	if len(r.context.Baggage) > 0 {
+		data.Baggage = make(map[string]string, len(r.context.Baggage))
+		for k, v := range r.context.Baggage {
+			data.Baggage[k] = v
+		}
+	}
+
+	return data
+}
+
+func isHTTPTag(tag string) bool {
+	switch tag {
+	case string(ext.SpanKind), string(ext.PeerHostname), string(ext.HTTPStatusCode), string(ext.HTTPMethod),
+		string(ext.HTTPUrl), httpParamsTag:
+		return true
+	default:
+		return strings.HasPrefix(tag, httpHeaderTagPrefix)
+	}
+}
+
+func isPostgresTag(tag string) bool {
+	switch tag {
+	case string(ext.SpanKind), string(ext.PeerHostname), string(ext.PeerPort), string(ext.DBType),
+		string(ext.DBUser), string(ext.DBInstance), string(ext.DBStatement):
+		return true
+	default:
+		return false
+	}
+}
+
+func isRPCTag(tag string) bool {
+	switch tag {
+	case string(ext.SpanKind), string(ext.PeerHostname), string(ext.PeerHostIPv4), string(ext.PeerPort),
+		string(ext.Component):
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *spanS) hasTag(tag string) bool {
+	_, ok := r.Tags[tag]
+
+	return ok
+}
+
+func (r *spanS) hasPeerTags() bool {
+	return r.hasTag(string(ext.PeerHostname)) || r.hasTag(string(ext.PeerHostIPv4)) || r.hasTag(string(ext.PeerService))
+}
+
+// errorMessage returns the message of the last error logged for this span
+func (r *spanS) errorMessage() string {
+	var msg string
+	for _, l := range r.Logs {
+		for _, f := range l.Fields {
+			if f.Key() != "error" {
+				continue
+			}
+
+			if err, ok := f.Value().(error); ok {
+				msg = err.Error()
+			} else {
+				msg = fmt.Sprint(f.Value())
+			}
+		}
+	}
+
+	return msg
+}
+
+func isPostgres(dbType string) bool {
+	switch strings.ToLower(dbType) {
+	case "postgres", "postgresql":
+		return true
+	default:
+		return false
+	}
+}
+
+// intValue converts integer tag values, such as the uint16 HTTP status code set with
+// ext.HTTPStatusCode.Set(), to int
+func intValue(v interface{}) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	case uint64:
+		return int(v)
+	default:
+		return 0
+	}
+}
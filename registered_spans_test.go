@@ -0,0 +1,192 @@
+package instana_test
+
+import (
+	"errors"
+	"testing"
+
+	instana "github.com/instana/go-sensor"
+	ot "github.com/opentracing/opentracing-go"
+	ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisteredSpan_HTTPEntry(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	sp := tracer.StartSpan("GET /users")
+	ext.SpanKindRPCServer.Set(sp)
+	sp.SetTag(string(ext.PeerHostname), "example.com")
+	sp.SetTag(string(ext.HTTPUrl), "/users")
+	sp.SetTag(string(ext.HTTPMethod), "GET")
+	sp.SetTag("http.params", "q=<redacted>")
+	sp.SetTag("http.header.X-Request-Id", "abc")
+	ext.HTTPStatusCode.Set(sp, 200)
+	sp.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "g.http", span.Name)
+	assert.Equal(t, 1, span.Kind)
+	assert.Nil(t, span.Data.SDK)
+
+	require.NotNil(t, span.Data.HTTP)
+	assert.Equal(t, "example.com", span.Data.HTTP.Host)
+	assert.Equal(t, "/users", span.Data.HTTP.URL)
+	assert.Equal(t, "GET", span.Data.HTTP.Method)
+	assert.Equal(t, 200, span.Data.HTTP.Status)
+	assert.Equal(t, "q=<redacted>", span.Data.HTTP.Params)
+	assert.Equal(t, map[string]string{"X-Request-Id": "abc"}, span.Data.HTTP.Headers)
+	assert.Nil(t, span.Data.Custom)
+}
+
+func TestRegisteredSpan_CustomData(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	sp := tracer.StartSpan("GET /users")
+	ext.SpanKindRPCServer.Set(sp)
+	sp.SetTag(string(ext.HTTPUrl), "/users")
+	sp.SetTag(string(ext.HTTPMethod), "GET")
+	sp.SetTag("tenant", "acme")
+	sp.SetBaggageItem("user", "42")
+	sp.LogKV("event", "cache miss")
+	sp.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "g.http", span.Name)
+	require.NotNil(t, span.Data.HTTP)
+
+	// tags reported in the registered span data are not duplicated
+	require.NotNil(t, span.Data.Custom)
+	assert.Equal(t, ot.Tags{"tenant": "acme"}, span.Data.Custom.Tags)
+	assert.Equal(t, map[string]string{"user": "42"}, span.Data.Custom.Baggage)
+	require.Len(t, span.Data.Custom.Logs, 1)
+	assert.Equal(t, "cache miss", span.Data.Custom.Logs[0].Fields["event"])
+}
+
+func TestRegisteredSpan_HTTPExitWithError(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	sp := tracer.StartSpan("client")
+	ext.SpanKindRPCClient.Set(sp)
+	sp.SetTag(string(ext.HTTPUrl), "https://example.com/users")
+	sp.SetTag(string(ext.HTTPMethod), "POST")
+	sp.SetTag(string(ext.HTTPStatusCode), 503)
+	sp.LogFields(log.Error(errors.New("service unavailable")))
+	sp.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "g.http", span.Name)
+	assert.Equal(t, 2, span.Kind)
+	assert.True(t, span.Error)
+
+	require.NotNil(t, span.Data.HTTP)
+	assert.Equal(t, "https://example.com/users", span.Data.HTTP.URL)
+	assert.Equal(t, 503, span.Data.HTTP.Status)
+	assert.Equal(t, "service unavailable", span.Data.HTTP.Error)
+}
+
+func TestRegisteredSpan_Postgres(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	sp := tracer.StartSpan("SELECT 1")
+	ext.SpanKindRPCClient.Set(sp)
+	sp.SetTag(string(ext.DBType), "postgres")
+	sp.SetTag(string(ext.PeerHostname), "db.local")
+	sp.SetTag(string(ext.PeerPort), uint16(5432))
+	sp.SetTag(string(ext.DBInstance), "users")
+	sp.SetTag(string(ext.DBUser), "app")
+	sp.SetTag(string(ext.DBStatement), "SELECT 1")
+	sp.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "postgres", span.Name)
+	assert.Equal(t, 2, span.Kind)
+
+	require.NotNil(t, span.Data.Postgres)
+	assert.Equal(t, "db.local", span.Data.Postgres.Host)
+	assert.Equal(t, "5432", span.Data.Postgres.Port)
+	assert.Equal(t, "users", span.Data.Postgres.DB)
+	assert.Equal(t, "app", span.Data.Postgres.User)
+	assert.Equal(t, "SELECT 1", span.Data.Postgres.Stmt)
+}
+
+func TestRegisteredSpan_RPC(t *testing.T) {
+	tests := map[string]struct {
+		kind     ext.SpanKindEnum
+		expected string
+		k        int
+	}{
+		"client": {ext.SpanKindRPCClientEnum, "rpc-client", 2},
+		"server": {ext.SpanKindRPCServerEnum, "rpc-server", 1},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := instana.NewTestRecorder()
+			tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+			sp := tracer.StartSpan("UserService.Get", ot.Tag{Key: string(ext.SpanKind), Value: test.kind})
+			sp.SetTag(string(ext.PeerHostname), "users.local")
+			sp.SetTag(string(ext.PeerPort), 9090)
+			sp.SetTag(string(ext.Component), "grpc")
+			sp.Finish()
+
+			spans := recorder.GetQueuedSpans()
+			require.Len(t, spans, 1)
+
+			span := spans[0]
+			assert.Equal(t, test.expected, span.Name)
+			assert.Equal(t, test.k, span.Kind)
+
+			require.NotNil(t, span.Data.RPC)
+			assert.Equal(t, "users.local", span.Data.RPC.Host)
+			assert.Equal(t, "9090", span.Data.RPC.Port)
+			assert.Equal(t, "UserService.Get", span.Data.RPC.Call)
+			assert.Equal(t, "grpc", span.Data.RPC.Flavor)
+		})
+	}
+}
+
+func TestRegisteredSpan_SDKFallback(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{}, recorder)
+
+	// intermediate spans are not reported as HTTP calls
+	sp := tracer.StartSpan("process")
+	sp.SetTag(string(ext.PeerHostname), "example.com")
+	sp.SetTag(string(ext.HTTPUrl), "/users")
+	sp.SetTag(string(ext.DBType), "redis")
+	sp.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "sdk", span.Name)
+	assert.Nil(t, span.Data.HTTP)
+	assert.Nil(t, span.Data.Postgres)
+	assert.Nil(t, span.Data.RPC)
+
+	require.NotNil(t, span.Data.SDK)
+	assert.Equal(t, "process", span.Data.SDK.Name)
+	assert.Equal(t, "redis", span.Data.SDK.Custom.Tags[string(ext.DBType)])
+	assert.Equal(t, "/users", span.Data.SDK.Custom.Tags[string(ext.HTTPUrl)])
+	assert.Nil(t, span.Data.Custom)
+}
@@ -0,0 +1,121 @@
+package instana
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Secrets matcher types supported by the host agent
+const (
+	SecretsMatcherEquals             = "equals"
+	SecretsMatcherEqualsIgnoreCase   = "equals-ignore-case"
+	SecretsMatcherContains           = "contains"
+	SecretsMatcherContainsIgnoreCase = "contains-ignore-case"
+	SecretsMatcherRegex              = "regex"
+)
+
+// redactedValue replaces the values of parameters matched as secrets
+const redactedValue = "<redacted>"
+
+// defaultSecretsMatcher is used until the host agent provides its own configuration
+var defaultSecretsMatcher = secretsMatcher{
+	Matcher: SecretsMatcherContainsIgnoreCase,
+	List:    []string{"key", "pass", "secret"},
+}
+
+type secretsMatcher struct {
+	Matcher string   `json:"matcher"`
+	List    []string `json:"list"`
+
+	regexps []*regexp.Regexp
+}
+
+// compile prepares the regular expressions for the regex matcher and ignores invalid ones
+func (m secretsMatcher) compile() secretsMatcher {
+	if m.Matcher != SecretsMatcherRegex {
+		return m
+	}
+
+	m.regexps = make([]*regexp.Regexp, 0, len(m.List))
+	for _, s := range m.List {
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			log.debug("ignoring invalid secrets regex", s, err)
+			continue
+		}
+
+		m.regexps = append(m.regexps, re)
+	}
+
+	return m
+}
+
+// Match returns true if s is considered a secret
+func (m secretsMatcher) Match(s string) bool {
+	switch m.Matcher {
+	case SecretsMatcherEquals:
+		for _, v := range m.List {
+			if s == v {
+				return true
+			}
+		}
+	case SecretsMatcherEqualsIgnoreCase:
+		for _, v := range m.List {
+			if strings.EqualFold(s, v) {
+				return true
+			}
+		}
+	case SecretsMatcherContains:
+		for _, v := range m.List {
+			if strings.Contains(s, v) {
+				return true
+			}
+		}
+	case SecretsMatcherContainsIgnoreCase:
+		ls := strings.ToLower(s)
+		for _, v := range m.List {
+			if strings.Contains(ls, strings.ToLower(v)) {
+				return true
+			}
+		}
+	case SecretsMatcherRegex:
+		for _, re := range m.regexps {
+			if re.MatchString(s) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// redactQuery replaces the values of query parameters considered secret
+func (m secretsMatcher) redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+
+	redacted := false
+	for k, vs := range params {
+		if !m.Match(k) {
+			continue
+		}
+
+		for i := range vs {
+			vs[i] = redactedValue
+		}
+		redacted = true
+	}
+
+	if !redacted {
+		return rawQuery
+	}
+
+	return params.Encode()
+}
@@ -0,0 +1,65 @@
+package instana
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretsMatcher(t *testing.T) {
+	InitSensor(&Options{})
+
+	tests := map[string]struct {
+		matcher  secretsMatcher
+		match    []string
+		mismatch []string
+	}{
+		SecretsMatcherEquals: {
+			matcher:  secretsMatcher{Matcher: SecretsMatcherEquals, List: []string{"key"}},
+			match:    []string{"key"},
+			mismatch: []string{"Key", "apikey"},
+		},
+		SecretsMatcherEqualsIgnoreCase: {
+			matcher:  secretsMatcher{Matcher: SecretsMatcherEqualsIgnoreCase, List: []string{"key"}},
+			match:    []string{"key", "KEY"},
+			mismatch: []string{"apikey"},
+		},
+		SecretsMatcherContains: {
+			matcher:  secretsMatcher{Matcher: SecretsMatcherContains, List: []string{"key"}},
+			match:    []string{"key", "apikey"},
+			mismatch: []string{"apiKey"},
+		},
+		SecretsMatcherContainsIgnoreCase: {
+			matcher:  defaultSecretsMatcher,
+			match:    []string{"apiKey", "PASSWORD", "client_secret"},
+			mismatch: []string{"user", "q"},
+		},
+		SecretsMatcherRegex: {
+			matcher:  secretsMatcher{Matcher: SecretsMatcherRegex, List: []string{"api_?key", "[invalid"}}.compile(),
+			match:    []string{"apikey", "api_key"},
+			mismatch: []string{"my_apikey", "[invalid"},
+		},
+		"unknown": {
+			matcher:  secretsMatcher{Matcher: "starts-with", List: []string{"key"}},
+			mismatch: []string{"key"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			for _, s := range test.match {
+				assert.True(t, test.matcher.Match(s), s)
+			}
+
+			for _, s := range test.mismatch {
+				assert.False(t, test.matcher.Match(s), s)
+			}
+		})
+	}
+}
+
+func TestSecretsMatcherRedactQuery(t *testing.T) {
+	assert.Equal(t, "", defaultSecretsMatcher.redactQuery(""))
+	assert.Equal(t, "q=term&page=2", defaultSecretsMatcher.redactQuery("q=term&page=2"))
+	assert.Equal(t, "api_key=%3Credacted%3E&q=term", defaultSecretsMatcher.redactQuery("q=term&api_key=1234"))
+}
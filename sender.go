@@ -0,0 +1,114 @@
+package instana
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+const (
+	// metricsQueueSize is the number of metrics payloads waiting for delivery. Since the agent
+	// only needs the latest values, there is no point in keeping more than one.
+	metricsQueueSize = 1
+	// eventsQueueSize is the number of events waiting for delivery
+	eventsQueueSize = 100
+)
+
+// sender delivers payloads of one type one at a time using a single worker goroutine, so that
+// a slow agent does not result in an unbounded number of pending requests. Payloads submitted
+// while the queue is full are dropped.
+type sender struct {
+	name    string
+	queue   chan interface{}
+	drains  chan chan struct{}
+	send    func(v interface{})
+	dropped uint64
+}
+
+func newSender(name string, size int, send func(v interface{})) *sender {
+	s := &sender{
+		name:   name,
+		queue:  make(chan interface{}, size),
+		drains: make(chan chan struct{}),
+		send:   send,
+	}
+
+	go s.run()
+
+	return s
+}
+
+func (s *sender) run() {
+	for {
+		select {
+		case v := <-s.queue:
+			s.send(v)
+		case done := <-s.drains:
+			// the worker is idle at this point, so only the queued payloads are left to deliver
+			for n := len(s.queue); n > 0; n-- {
+				s.send(<-s.queue)
+			}
+
+			close(done)
+		}
+	}
+}
+
+// drain blocks until the payload being currently delivered and all payloads queued before
+// the call are sent or the context is done
+func (s *sender) drain(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	done := make(chan struct{})
+
+	select {
+	case s.drains <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// submit enqueues v for delivery and returns false if the queue is full
+func (s *sender) submit(v interface{}) bool {
+	select {
+	case s.queue <- v:
+		return true
+	default:
+		n := atomic.AddUint64(&s.dropped, 1)
+		log.debug("agent is not keeping up, dropped", s.name, "payload, total dropped:", n)
+
+		return false
+	}
+}
+
+// droppedCount returns the number of payloads dropped because the queue was full
+func (s *sender) droppedCount() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// trigger is a coalescing signal: any number of calls to fire() made while the previous
+// signal has not been consumed yet result in a single pending signal.
+type trigger chan struct{}
+
+func newTrigger() trigger {
+	return make(trigger, 1)
+}
+
+func (t trigger) fire() {
+	select {
+	case t <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,100 @@
+package instana
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSender(t *testing.T) {
+	InitSensor(&Options{})
+
+	started, release := make(chan interface{}), make(chan struct{})
+	sent := make(chan interface{}, 10)
+
+	s := newSender("test", 1, func(v interface{}) {
+		started <- v
+		<-release
+		sent <- v
+	})
+
+	// the first payload is picked up by the worker immediately
+	require.True(t, s.submit(1))
+	assert.Equal(t, 1, <-started)
+
+	// the second one waits in the queue, while the third one is dropped
+	assert.True(t, s.submit(2))
+	assert.False(t, s.submit(3))
+	assert.Equal(t, uint64(1), s.droppedCount())
+
+	close(release)
+	assert.Equal(t, 2, <-started)
+
+	assert.Equal(t, 1, <-sent)
+	assert.Equal(t, 2, <-sent)
+
+	select {
+	case v := <-sent:
+		t.Errorf("unexpected payload sent: %v", v)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSenderDrain(t *testing.T) {
+	InitSensor(&Options{})
+
+	started, release := make(chan interface{}), make(chan struct{})
+	sent := make(chan interface{}, 10)
+
+	s := newSender("test", 2, func(v interface{}) {
+		started <- v
+		<-release
+		sent <- v
+	})
+
+	require.True(t, s.submit(1))
+	assert.Equal(t, 1, <-started)
+	require.True(t, s.submit(2))
+
+	drained := make(chan error, 1)
+	go func() { drained <- s.drain(context.Background()) }()
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned while the payload was being sent")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	<-started
+
+	require.NoError(t, <-drained)
+	assert.Len(t, sent, 2)
+
+	// the context deadline is respected
+	release = make(chan struct{})
+	defer close(release)
+
+	require.True(t, s.submit(3))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, s.drain(ctx))
+}
+
+func TestTrigger(t *testing.T) {
+	tr := newTrigger()
+
+	tr.fire()
+	tr.fire()
+	tr.fire()
+
+	assert.Len(t, tr, 1)
+	<-tr
+	assert.Len(t, tr, 0)
+}
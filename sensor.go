@@ -3,6 +3,7 @@ package instana
 import (
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 const (
@@ -15,6 +16,9 @@ type sensorS struct {
 	agent       *agentS
 	options     *Options
 	serviceName string
+
+	recordersMu sync.Mutex
+	recorders   []*Recorder
 }
 
 var sensor *sensorS
@@ -26,6 +30,7 @@ func (r *sensorS) init(options *Options) {
 		r.configureServiceName()
 		r.agent = r.initAgent()
 		r.meter = r.initMeter()
+		r.agent.lifecycle.started()
 	}
 }
 
@@ -42,6 +47,45 @@ func (r *sensorS) setOptions(options *Options) {
 	if r.options.ForceTransmissionStartingAt == 0 {
 		r.options.ForceTransmissionStartingAt = DefaultForceSpanSendAt
 	}
+
+	if r.options.MaxCustomMetrics == 0 {
+		r.options.MaxCustomMetrics = DefaultMaxCustomMetrics
+	}
+	customMetrics.setLimit(r.options.MaxCustomMetrics)
+
+	if r.options.MetricsInterval <= 0 {
+		r.options.MetricsInterval = DefaultMetricsInterval
+	}
+
+	if r.options.FullMetricsEvery <= 0 {
+		r.options.FullMetricsEvery = DefaultFullMetricsEvery
+	}
+
+	if r.options.MaxSpansPerBatch <= 0 {
+		r.options.MaxSpansPerBatch = DefaultMaxSpansPerBatch
+	}
+
+	if r.options.MaxBatchBytes <= 0 {
+		r.options.MaxBatchBytes = DefaultMaxBatchBytes
+	}
+	if r.options.Clock == nil {
+		r.options.Clock = SystemClock
+	}
+}
+
+// addRecorder registers a recorder to be flushed by Flush()
+func (r *sensorS) addRecorder(rec *Recorder) {
+	r.recordersMu.Lock()
+	defer r.recordersMu.Unlock()
+
+	r.recorders = append(r.recorders, rec)
+}
+
+func (r *sensorS) getRecorders() []*Recorder {
+	r.recordersMu.Lock()
+	defer r.recordersMu.Unlock()
+
+	return append([]*Recorder(nil), r.recorders...)
 }
 
 func (r *sensorS) getOptions() *Options {
@@ -62,6 +106,10 @@ func (r *sensorS) configureServiceName() {
 // and reporting metrics.
 func InitSensor(options *Options) {
 	if sensor == nil {
+		if options == nil {
+			options = &Options{}
+		}
+
 		sensor = new(sensorS)
 		// If this environment variable is set, then override log level
 		_, ok := os.LookupEnv("INSTANA_DEBUG")
@@ -0,0 +1,105 @@
+package instana
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Serverless endpoint headers
+const (
+	serverlessKeyHeader  = "X-Instana-Key"
+	serverlessTimeHeader = "X-Instana-Time"
+)
+
+// serverlessEndpoint is used instead of the host agent in environments where there is
+// no agent available, such as AWS Lambda or AWS Fargate. The serverless mode is enabled
+// by setting both INSTANA_ENDPOINT_URL and INSTANA_AGENT_KEY env variables.
+type serverlessEndpoint struct {
+	url string
+	key string
+}
+
+func newServerlessEndpointFromEnv() *serverlessEndpoint {
+	url, key := os.Getenv("INSTANA_ENDPOINT_URL"), os.Getenv("INSTANA_AGENT_KEY")
+	if url == "" {
+		return nil
+	}
+
+	if key == "" {
+		log.warn("INSTANA_ENDPOINT_URL is set, but INSTANA_AGENT_KEY is missing, falling back to the host agent")
+		return nil
+	}
+
+	return &serverlessEndpoint{
+		url: strings.TrimRight(url, "/"),
+		key: key,
+	}
+}
+
+// makeURL maps the host agent resource prefix to the serverless endpoint URL
+func (r *serverlessEndpoint) makeURL(prefix string) string {
+	switch prefix {
+	case agentTracesURL:
+		return r.url + "/traces"
+	case agentDataURL:
+		return r.url + "/metrics"
+	case agentEventURL:
+		return r.url + "/events"
+	}
+
+	return r.url + prefix
+}
+
+// setHeaders sets the authentication headers along with the time the request has been sent at
+func (r *serverlessEndpoint) setHeaders(h http.Header, now time.Time) {
+	h.Set(serverlessKeyHeader, r.key)
+	h.Set(serverlessTimeHeader, strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10))
+}
+
+// Flush synchronously sends all queued spans, events and the current metrics to the host agent
+// or the serverless endpoint. Payloads that are being sent in background at the time of the call
+// are delivered before Flush returns. In serverless environments this function should be called
+// at the end of each invocation, since the process may be frozen or terminated right after the
+// handler returns.
+func Flush(ctx context.Context) error {
+	if sensor == nil {
+		return errors.New("instana: sensor is not initialized")
+	}
+
+	if !sensor.agent.canSend() {
+		return errors.New("instana: agent is not ready")
+	}
+
+	var errs []string
+	for _, rec := range sensor.getRecorders() {
+		if err := rec.flush(ctx); err != nil {
+			errs = append(errs, "failed to send spans: "+err.Error())
+		}
+	}
+
+	if err := sensor.agent.events.drain(ctx); err != nil {
+		errs = append(errs, "failed to send events: "+err.Error())
+	}
+
+	// wait for the periodic payload to be delivered, so that it does not override the current one
+	if err := sensor.meter.sender.drain(ctx); err != nil {
+		errs = append(errs, "failed to send metrics: "+err.Error())
+	}
+
+	if d := sensor.meter.collect(); d != nil {
+		if err := sensor.agent.transport.SendMetrics(ctx, d); err != nil {
+			errs = append(errs, "failed to send metrics: "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("instana: " + strings.Join(errs, ", "))
+	}
+
+	return nil
+}
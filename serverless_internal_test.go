@@ -0,0 +1,189 @@
+package instana
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServerlessEndpointFromEnv(t *testing.T) {
+	InitSensor(&Options{})
+
+	defer restoreEnvVarFunc("INSTANA_ENDPOINT_URL")()
+	defer restoreEnvVarFunc("INSTANA_AGENT_KEY")()
+
+	os.Unsetenv("INSTANA_ENDPOINT_URL")
+	os.Unsetenv("INSTANA_AGENT_KEY")
+	assert.Nil(t, newServerlessEndpointFromEnv())
+
+	os.Setenv("INSTANA_ENDPOINT_URL", "https://serverless.instana.io/")
+	assert.Nil(t, newServerlessEndpointFromEnv(), "agent key is required")
+
+	os.Setenv("INSTANA_AGENT_KEY", "secret")
+	ep := newServerlessEndpointFromEnv()
+	require.NotNil(t, ep)
+
+	assert.Equal(t, "https://serverless.instana.io/traces", ep.makeURL(agentTracesURL))
+	assert.Equal(t, "https://serverless.instana.io/metrics", ep.makeURL(agentDataURL))
+	assert.Equal(t, "https://serverless.instana.io/events", ep.makeURL(agentEventURL))
+}
+
+func TestFlushServerless(t *testing.T) {
+	InitSensor(&Options{})
+
+	clock := newManualClock(time.Unix(1600000000, 123000000))
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]byte)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "secret", req.Header.Get(serverlessKeyHeader))
+		assert.Equal(t, "1600000000123", req.Header.Get(serverlessTimeHeader))
+
+		body := io.Reader(req.Body)
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(req.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+
+		b, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received[req.URL.Path] = b
+	}))
+	defer srv.Close()
+
+	defer restoreEnvVarFunc("INSTANA_ENDPOINT_URL")()
+	defer restoreEnvVarFunc("INSTANA_AGENT_KEY")()
+	os.Setenv("INSTANA_ENDPOINT_URL", srv.URL)
+	os.Setenv("INSTANA_AGENT_KEY", "secret")
+
+	// Replace the global sensor for the duration of this test
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{Service: "lambda", MetricsInterval: time.Hour, Clock: clock})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+	sensor.meter = sensor.initMeter()
+
+	require.NotNil(t, sensor.agent.endpoint)
+	assert.True(t, sensor.agent.canSend())
+
+	recorder := NewTestRecorder()
+	sensor.addRecorder(recorder)
+
+	tracer := NewTracerWithEverything(sensor.options, recorder)
+	tracer.StartSpan("handler").Finish()
+
+	require.NoError(t, Flush(context.Background()))
+	assert.Equal(t, 0, recorder.QueuedSpansCount())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var spans []map[string]interface{}
+	require.NoError(t, json.Unmarshal(received["/traces"], &spans))
+	require.Len(t, spans, 1)
+	assert.Equal(t, "handler", spans[0]["data"].(map[string]interface{})["sdk"].(map[string]interface{})["name"])
+
+	var metrics map[string]interface{}
+	require.NoError(t, json.Unmarshal(received["/metrics"], &metrics))
+	assert.Contains(t, metrics, "metrics")
+	assert.Contains(t, metrics, "snapshot")
+}
+
+// blockingTransport holds the delivery of spans until released
+type blockingTransport struct {
+	*InMemoryTransport
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) SendSpans(ctx context.Context, spans interface{}) error {
+	t.started <- struct{}{}
+	<-t.release
+
+	return t.InMemoryTransport.SendSpans(ctx, spans)
+}
+
+func TestFlushWaitsForInFlightData(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := &blockingTransport{
+		InMemoryTransport: NewInMemoryTransport(1234),
+		started:           make(chan struct{}, 10),
+		release:           make(chan struct{}),
+	}
+
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{
+		Service:         "test",
+		MetricsInterval: time.Hour,
+		Transport:       tr,
+		Clock:           newManualClock(time.Now()),
+	})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+	sensor.meter = sensor.initMeter()
+
+	require.Eventually(t, sensor.agent.canSend, time.Second, 10*time.Millisecond, "agent never became ready")
+
+	recorder := NewRecorder()
+	tracer := NewTracerWithEverything(sensor.options, recorder)
+
+	tracer.StartSpan("in-flight").Finish()
+	recorder.flushes.fire()
+	<-tr.started
+
+	tracer.StartSpan("queued").Finish()
+	require.True(t, sensor.agent.events.submit(&EventData{Title: "queued"}))
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- Flush(context.Background()) }()
+
+	select {
+	case err := <-flushed:
+		t.Fatalf("flush returned while spans were being sent: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(tr.release)
+	require.NoError(t, <-flushed)
+
+	spans := tr.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "in-flight", spans[0]["data"].(map[string]interface{})["sdk"].(map[string]interface{})["name"])
+	assert.Equal(t, "queued", spans[1]["data"].(map[string]interface{})["sdk"].(map[string]interface{})["name"])
+
+	assert.Len(t, tr.Events(), 1)
+	assert.Len(t, tr.Metrics(), 1)
+}
+
+func restoreEnvVarFunc(key string) func() {
+	if oldValue, ok := os.LookupEnv(key); ok {
+		return func() { os.Setenv(key, oldValue) }
+	}
+
+	return func() { os.Unsetenv(key) }
+}
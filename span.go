@@ -1,11 +1,15 @@
 package instana
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	bt "github.com/opentracing/basictracer-go"
 	ot "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	otlog "github.com/opentracing/opentracing-go/log"
@@ -24,6 +28,20 @@ type spanS struct {
 	Logs         []ot.LogRecord
 	Error        bool
 	Ec           int
+
+	// numDroppedLogs is the number of logs that did not fit into MaxLogsPerSpan
+	numDroppedLogs int
+
+	// event is the listener created by TracerOptions.NewSpanEventListener. Events are only
+	// created if it's set, since converting them to bt.SpanEvent allocates.
+	event func(bt.SpanEvent)
+}
+
+// spanPool keeps finished spans for reuse if TracerOptions.EnableSpanPool is set
+var spanPool = sync.Pool{
+	New: func() interface{} {
+		return new(spanS)
+	},
 }
 
 func (r *spanS) BaggageItem(key string) string {
@@ -34,6 +52,10 @@ func (r *spanS) BaggageItem(key string) string {
 }
 
 func (r *spanS) SetBaggageItem(key, val string) ot.Span {
+	if r.event != nil {
+		r.event(bt.EventBaggage{Key: key, Value: val})
+	}
+
 	if r.trim() {
 		return r
 	}
@@ -56,12 +78,11 @@ func (r *spanS) Finish() {
 func (r *spanS) FinishWithOptions(opts ot.FinishOptions) {
 	finishTime := opts.FinishTime
 	if finishTime.IsZero() {
-		finishTime = time.Now()
+		finishTime = r.tracer.options.Clock.Now()
 	}
 
 	duration := finishTime.Sub(r.Start)
 	r.Lock()
-	defer r.Unlock()
 	for _, lr := range opts.LogRecords {
 		r.appendLog(lr)
 	}
@@ -70,28 +91,95 @@ func (r *spanS) FinishWithOptions(opts ot.FinishOptions) {
 		r.appendLog(ld.ToLogRecord())
 	}
 
+	if r.numDroppedLogs > 0 {
+		r.finalizeLogs()
+	}
+
 	r.Duration = duration
-	r.tracer.options.Recorder.RecordSpan(r)
+	r.Unlock()
+
+	// span processors are allowed to modify the span, so they need to be run without holding the lock
+	if r.onFinish() {
+		r.Lock()
+		r.tracer.options.Recorder.RecordSpan(r)
+		r.Unlock()
+	}
+
+	r.release()
+}
+
+// release returns the finished span to the pool if span pooling is enabled. The span must not
+// be used afterwards. Tags, logs and baggage are not reused, since the recorder and the span
+// event listeners are allowed to keep them.
+func (r *spanS) release() {
+	if !r.tracer.options.EnableSpanPool {
+		return
+	}
+
+	*r = spanS{}
+	spanPool.Put(r)
 }
 
 func (r *spanS) appendLog(lr ot.LogRecord) {
 	maxLogs := r.tracer.options.MaxLogsPerSpan
 	if maxLogs == 0 || len(r.Logs) < maxLogs {
 		r.Logs = append(r.Logs, lr)
+		return
 	}
+
+	// There are too many logs already. The first numOld logs are kept untouched,
+	// while the rest is used as a ring buffer where the oldest log gets overwritten.
+	numOld, numNew := splitMaxLogs(maxLogs)
+	r.Logs[numOld+r.numDroppedLogs%numNew] = lr
+	r.numDroppedLogs++
+}
+
+// finalizeLogs restores the chronological order of logs stored in the ring buffer and
+// replaces the oldest of the newest logs with a record counting the dropped ones
+func (r *spanS) finalizeLogs() {
+	numOld, numNew := splitMaxLogs(r.tracer.options.MaxLogsPerSpan)
+
+	newest := r.Logs[numOld:]
+	pos := r.numDroppedLogs % numNew
+
+	rotated := make([]ot.LogRecord, 0, len(newest))
+	rotated = append(rotated, newest[pos:]...)
+	rotated = append(rotated, newest[:pos]...)
+	copy(newest, rotated)
+
+	// The marker takes the place of a kept log, so this one is counted as dropped as well.
+	// The timestamp of the overwritten log is preserved to keep the order.
+	r.Logs[numOld] = ot.LogRecord{
+		Timestamp: r.Logs[numOld].Timestamp,
+		Fields: []otlog.Field{
+			otlog.String("event", "dropped Span logs"),
+			otlog.Int("dropped_log_count", r.numDroppedLogs+1),
+		},
+	}
+}
+
+// splitMaxLogs returns the number of oldest and newest logs to keep for given MaxLogsPerSpan
+func splitMaxLogs(maxLogs int) (int, int) {
+	numOld := (maxLogs - 1) / 2
+
+	return numOld, maxLogs - numOld
 }
 
 func (r *spanS) Log(ld ot.LogData) {
+	if ld.Timestamp.IsZero() {
+		ld.Timestamp = r.tracer.options.Clock.Now()
+	}
+
+	if r.event != nil {
+		r.event(bt.EventLog(ld))
+	}
+
 	r.Lock()
 	defer r.Unlock()
 	if r.trim() || r.tracer.options.DropAllLogs {
 		return
 	}
 
-	if ld.Timestamp.IsZero() {
-		ld.Timestamp = time.Now()
-	}
-
 	r.appendLog(ld.ToLogRecord())
 }
 
@@ -121,7 +209,12 @@ func (r *spanS) LogFields(fields ...otlog.Field) {
 	}
 
 	lr := ot.LogRecord{
-		Fields: fields,
+		Timestamp: r.tracer.options.Clock.Now(),
+		Fields:    fields,
+	}
+
+	if r.event != nil {
+		r.event(bt.EventLogFields(lr))
 	}
 
 	r.Lock()
@@ -130,10 +223,6 @@ func (r *spanS) LogFields(fields ...otlog.Field) {
 		return
 	}
 
-	if lr.Timestamp.IsZero() {
-		lr.Timestamp = time.Now()
-	}
-
 	r.appendLog(lr)
 }
 
@@ -157,6 +246,10 @@ func (r *spanS) SetOperationName(operationName string) ot.Span {
 }
 
 func (r *spanS) SetTag(key string, value interface{}) ot.Span {
+	if r.event != nil {
+		r.event(bt.EventTag{Key: key, Value: value})
+	}
+
 	r.Lock()
 	defer r.Unlock()
 	if r.trim() {
@@ -249,17 +342,57 @@ func (r *spanS) getSpanKindInt() int {
 	return 3
 }
 
-func (r *spanS) collectLogs() map[uint64]map[string]interface{} {
-	logs := make(map[uint64]map[string]interface{})
-	for _, l := range r.Logs {
-		if _, ok := logs[uint64(l.Timestamp.UnixNano())/uint64(time.Millisecond)]; !ok {
-			logs[uint64(l.Timestamp.UnixNano())/uint64(time.Millisecond)] = make(map[string]interface{})
-		}
+func (r *spanS) collectLogs() []jsonLogRecord {
+	if len(r.Logs) == 0 {
+		return nil
+	}
 
+	logs := make([]jsonLogRecord, 0, len(r.Logs))
+	for _, l := range r.Logs {
+		fields := make(map[string]interface{}, len(l.Fields))
 		for _, f := range l.Fields {
-			logs[uint64(l.Timestamp.UnixNano())/uint64(time.Millisecond)][f.Key()] = f.Value()
+			fields[f.Key()] = logFieldValue(f)
 		}
+
+		logs = append(logs, jsonLogRecord{
+			Timestamp: uint64(l.Timestamp.UnixNano()) / uint64(time.Millisecond),
+			Fields:    fields,
+		})
 	}
 
 	return logs
 }
+
+// logFieldValue returns the value of a log field that can be serialized to JSON. Errors are
+// reported using their messages and non-finite floats as strings, while objects that cannot be
+// serialized are converted to their string representation, so that they don't prevent the whole
+// span from being sent. Other objects are encoded once and kept as raw JSON.
+func logFieldValue(f otlog.Field) interface{} {
+	switch v := f.Value().(type) {
+	case nil, string, bool, int, int32, int64, uint32, uint64:
+		return v
+	case float32:
+		return finiteFloatValue(float64(v), v)
+	case float64:
+		return finiteFloatValue(v, v)
+	case error:
+		return v.Error()
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%+v", v)
+		}
+
+		return json.RawMessage(b)
+	}
+}
+
+// finiteFloatValue returns v unchanged if f is a finite number and its string representation
+// otherwise, since NaN and infinite values cannot be encoded as JSON numbers
+func finiteFloatValue(f float64, v interface{}) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return v
+}
@@ -0,0 +1,174 @@
+package instana
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMaxSpansPerBatch is the default maximum number of spans sent to the agent in one request
+	DefaultMaxSpansPerBatch = 500
+	// DefaultMaxBatchBytes is the default maximum size of the JSON-encoded spans batch sent to the
+	// agent in one request before compression
+	DefaultMaxBatchBytes = 1 << 20
+)
+
+// encodeSpansBatch streams spans into w as a JSON array until either maxSpans or maxBytes is reached
+// and returns the number of spans consumed. The first span is always written, even if it exceeds maxBytes.
+// Spans that cannot be encoded are logged and skipped, but count as consumed.
+func encodeSpansBatch(w io.Writer, spans []jsonSpan, maxSpans, maxBytes int) (int, error) {
+	var (
+		buf     bytes.Buffer
+		written = 1
+	)
+
+	enc := json.NewEncoder(&buf)
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return 0, err
+	}
+
+	n, sent := 0, 0
+	for n < len(spans) && (maxSpans <= 0 || n < maxSpans) {
+		buf.Reset()
+		if sent > 0 {
+			buf.WriteByte(',')
+		}
+
+		if err := enc.Encode(spans[n]); err != nil {
+			log.warn("failed to encode span", spans[n].SpanID, "dropping it:", err)
+			n++
+
+			continue
+		}
+
+		// the encoder terminates each value with a newline
+		buf.Truncate(buf.Len() - 1)
+
+		// reserve one byte for the closing bracket
+		if sent > 0 && maxBytes > 0 && written+buf.Len()+1 > maxBytes {
+			break
+		}
+
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return n, err
+		}
+
+		written += buf.Len()
+		n++
+		sent++
+	}
+
+	if _, err := io.WriteString(w, "]"); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Compression support states of the agent
+const (
+	gzipUnknown int32 = iota
+	gzipSupported
+	gzipUnsupported
+)
+
+// sendSpans posts spans to the agent in batches limited by Options.MaxSpansPerBatch and
+// Options.MaxBatchBytes. Each batch is encoded while it is being sent, so the encoded payload
+// is never held in memory as a whole. Batches are gzip-compressed unless the agent has rejected
+// the first compressed request as unsupported.
+func (r *agentS) sendSpans(ctx context.Context, url string, spans []jsonSpan) error {
+	for len(spans) > 0 {
+		state := atomic.LoadInt32(&r.gzipState)
+		gzipped := state != gzipUnsupported
+
+		n, err := r.postSpansBatch(ctx, url, spans, gzipped)
+		if gzipped && state == gzipUnknown {
+			if isGzipRejected(err) {
+				log.debug("agent rejected compressed spans with", err, "falling back to uncompressed requests")
+				atomic.StoreInt32(&r.gzipState, gzipUnsupported)
+
+				continue
+			}
+
+			if err == nil {
+				atomic.StoreInt32(&r.gzipState, gzipSupported)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		spans = spans[n:]
+	}
+
+	return nil
+}
+
+// isGzipRejected returns whether err means that the agent does not accept compressed requests.
+// Other client errors, such as 404 returned while the process is being announced again, are
+// not related to the payload encoding and do not disable the compression.
+func isGzipRejected(err error) bool {
+	e, ok := err.(*agentStatusError)
+	if !ok {
+		return false
+	}
+
+	return e.StatusCode == http.StatusUnsupportedMediaType || e.StatusCode == http.StatusBadRequest
+}
+
+// postSpansBatch streams the next batch of spans to the agent and returns the number of sent spans
+func (r *agentS) postSpansBatch(ctx context.Context, url string, spans []jsonSpan, gzipped bool) (int, error) {
+	type encodeResult struct {
+		n   int
+		err error
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan encodeResult, 1)
+
+	go func() {
+		n, err := r.encodeSpans(pw, spans, gzipped)
+		pw.CloseWithError(err)
+		done <- encodeResult{n, err}
+	}()
+
+	var contentEncoding string
+	if gzipped {
+		contentEncoding = "gzip"
+	}
+
+	_, err := r.streamRequestResponse(ctx, url, "POST", pr, contentEncoding, nil, "")
+
+	// unblock the encoder in case the request has failed before the payload was fully read
+	pr.Close()
+
+	res := <-done
+	if res.err != nil && res.err != io.ErrClosedPipe {
+		log.debug("failed to encode spans:", res.err)
+		return res.n, res.err
+	}
+
+	return res.n, err
+}
+
+func (r *agentS) encodeSpans(w io.Writer, spans []jsonSpan, gzipped bool) (int, error) {
+	maxSpans, maxBytes := r.sensor.options.MaxSpansPerBatch, r.sensor.options.MaxBatchBytes
+	if !gzipped {
+		return encodeSpansBatch(w, spans, maxSpans, maxBytes)
+	}
+
+	zw := gzip.NewWriter(w)
+	n, err := encodeSpansBatch(zw, spans, maxSpans, maxBytes)
+	if err != nil {
+		return n, err
+	}
+
+	return n, zw.Close()
+}
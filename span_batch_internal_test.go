@@ -0,0 +1,278 @@
+package instana
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeSpansBatch(t *testing.T) {
+	spans := make([]jsonSpan, 5)
+	for i := range spans {
+		spans[i] = jsonSpan{TraceID: int64(i + 1), SpanID: int64(i + 1), Name: "sdk"}
+	}
+
+	spanSize := func(sp jsonSpan) int {
+		b, err := json.Marshal(sp)
+		require.NoError(t, err)
+
+		return len(b)
+	}
+
+	t.Run("no limits", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := encodeSpansBatch(&buf, spans, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		expected, err := json.Marshal(spans)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(expected), buf.String())
+	})
+
+	t.Run("spans limit", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := encodeSpansBatch(&buf, spans, 2, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		var decoded []jsonSpan
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Len(t, decoded, 2)
+	})
+
+	t.Run("bytes limit", func(t *testing.T) {
+		// room for exactly 3 spans: brackets + 3 spans + 2 commas
+		maxBytes := 2 + spanSize(spans[0])*3 + 2
+
+		var buf bytes.Buffer
+		n, err := encodeSpansBatch(&buf, spans, 0, maxBytes)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		assert.True(t, buf.Len() <= maxBytes)
+
+		var decoded []jsonSpan
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Len(t, decoded, 3)
+	})
+
+	t.Run("unsupported value", func(t *testing.T) {
+		invalid := jsonSpan{SpanID: 10, Data: &jsonData{
+			SDK: &jsonSDKData{Custom: &jsonCustomData{Tags: map[string]interface{}{"value": math.Inf(1)}}},
+		}}
+
+		var buf bytes.Buffer
+		n, err := encodeSpansBatch(&buf, []jsonSpan{invalid, spans[0], invalid, spans[1]}, 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+
+		expected, err := json.Marshal(spans[:2])
+		require.NoError(t, err)
+		assert.JSONEq(t, string(expected), buf.String())
+	})
+
+	t.Run("oversized span", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := encodeSpansBatch(&buf, spans, 0, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestAgentSendSpans(t *testing.T) {
+	InitSensor(&Options{})
+
+	var (
+		mu      sync.Mutex
+		batches [][]jsonSpan
+		gzipped []bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		compressed := req.Header.Get("Content-Encoding") == "gzip"
+		gzipped = append(gzipped, compressed)
+
+		// Simulate an agent that does not support compression
+		if compressed {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var batch []jsonSpan
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&batch))
+		batches = append(batches, batch)
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{MaxSpansPerBatch: 2, MaxBatchBytes: DefaultMaxBatchBytes})
+
+	spans := make([]jsonSpan, 5)
+	for i := range spans {
+		spans[i] = jsonSpan{SpanID: int64(i + 1)}
+	}
+
+	require.NoError(t, agent.sendSpans(context.Background(), srv.URL, spans))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, []bool{true, false, false, false}, gzipped)
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 2)
+	assert.Len(t, batches[2], 1)
+	assert.Equal(t, int64(5), batches[2][0].SpanID)
+}
+
+func TestAgentSendSpansGzip(t *testing.T) {
+	InitSensor(&Options{})
+
+	received := make(chan []jsonSpan, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+		// the payload is streamed, so its size is not known in advance
+		assert.Equal(t, int64(-1), req.ContentLength)
+
+		zr, err := gzip.NewReader(req.Body)
+		require.NoError(t, err)
+
+		var batch []jsonSpan
+		require.NoError(t, json.NewDecoder(zr).Decode(&batch))
+		received <- batch
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{MaxSpansPerBatch: 10, MaxBatchBytes: DefaultMaxBatchBytes})
+	require.NoError(t, agent.sendSpans(context.Background(), srv.URL, []jsonSpan{{SpanID: 1}}))
+
+	assert.Equal(t, []jsonSpan{{SpanID: 1}}, <-received)
+}
+
+func TestAgentSendSpansGzipSupported(t *testing.T) {
+	InitSensor(&Options{})
+
+	var (
+		mu      sync.Mutex
+		gzipped []bool
+		fail    bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		gzipped = append(gzipped, req.Header.Get("Content-Encoding") == "gzip")
+		if fail {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{MaxSpansPerBatch: 10, MaxBatchBytes: DefaultMaxBatchBytes})
+	require.NoError(t, agent.sendSpans(context.Background(), srv.URL, []jsonSpan{{SpanID: 1}}))
+
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+
+	// once the agent has accepted compressed spans, client errors do not disable the compression
+	assert.Error(t, agent.sendSpans(context.Background(), srv.URL, []jsonSpan{{SpanID: 2}}))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, []bool{true, true}, gzipped)
+}
+
+func TestAgentSendSpansEncodingError(t *testing.T) {
+	InitSensor(&Options{})
+
+	received := make(chan []jsonSpan, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var batch []jsonSpan
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&batch))
+		received <- batch
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{MaxSpansPerBatch: 10, MaxBatchBytes: DefaultMaxBatchBytes})
+
+	spans := []jsonSpan{
+		{SpanID: 1, Data: &jsonData{
+			SDK: &jsonSDKData{Custom: &jsonCustomData{Tags: map[string]interface{}{"value": math.NaN()}}},
+		}},
+		{SpanID: 2},
+	}
+
+	// the span that cannot be encoded is dropped without affecting the rest of the batch
+	n, err := agent.postSpansBatch(context.Background(), srv.URL, spans, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	assert.Equal(t, []jsonSpan{{SpanID: 2}}, <-received)
+}
+
+func TestAgentSendSpansNotFound(t *testing.T) {
+	InitSensor(&Options{})
+
+	var (
+		mu      sync.Mutex
+		gzipped []bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		gzipped = append(gzipped, req.Header.Get("Content-Encoding") == "gzip")
+
+		// the agent responds with 404 while the process is being announced
+		if len(gzipped) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	agent := newTestAgent(t, srv, &Options{MaxSpansPerBatch: 10, MaxBatchBytes: DefaultMaxBatchBytes})
+
+	assert.Error(t, agent.sendSpans(context.Background(), srv.URL, []jsonSpan{{SpanID: 1}}))
+	assert.Equal(t, gzipUnknown, atomic.LoadInt32(&agent.gzipState))
+
+	require.NoError(t, agent.sendSpans(context.Background(), srv.URL, []jsonSpan{{SpanID: 2}}))
+	assert.Equal(t, gzipSupported, atomic.LoadInt32(&agent.gzipState))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, []bool{true, true}, gzipped)
+}
+
+// newTestAgent returns an agent configured to send requests to the test server
+func newTestAgent(t testing.TB, srv *httptest.Server, opts *Options) *agentS {
+	host, portStr, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	opts.AgentPort = port
+
+	return &agentS{
+		sensor: &sensorS{options: opts},
+		from:   &fromS{PID: "1234"},
+		host:   host,
+		client: http.DefaultClient,
+	}
+}
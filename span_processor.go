@@ -0,0 +1,157 @@
+package instana
+
+import (
+	bt "github.com/opentracing/basictracer-go"
+	ot "github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// ProcessedSpan is the view of a span passed to span processors. Along with the
+// opentracing.Span methods it provides read access to the span data.
+type ProcessedSpan interface {
+	ot.Span
+
+	// OperationName returns the span operation name
+	OperationName() string
+	// TagValue returns the value of a span tag and whether it has been set
+	TagValue(key string) (interface{}, bool)
+}
+
+// SpanProcessor is invoked by the tracer for each span it starts and finishes. Processors can
+// be used to enrich spans with additional tags, rewrite existing ones or drop the spans that
+// should not be reported, such as health checks.
+type SpanProcessor interface {
+	// OnStart is called right after the span has been started
+	OnStart(sp ProcessedSpan)
+	// OnFinish is called after the span has been finished and before it is passed to the
+	// recorder. If OnFinish returns false, the span is dropped and the processors following
+	// this one are not invoked.
+	OnFinish(sp ProcessedSpan) (keep bool)
+}
+
+// SpanProcessorFuncs is an adapter to use a pair of functions as a SpanProcessor. Both functions
+// are optional, a nil OnFinishFunc keeps all spans.
+type SpanProcessorFuncs struct {
+	OnStartFunc  func(sp ProcessedSpan)
+	OnFinishFunc func(sp ProcessedSpan) bool
+}
+
+// OnStart calls f.OnStartFunc(sp)
+func (f SpanProcessorFuncs) OnStart(sp ProcessedSpan) {
+	if f.OnStartFunc != nil {
+		f.OnStartFunc(sp)
+	}
+}
+
+// OnFinish calls f.OnFinishFunc(sp)
+func (f SpanProcessorFuncs) OnFinish(sp ProcessedSpan) bool {
+	if f.OnFinishFunc == nil {
+		return true
+	}
+
+	return f.OnFinishFunc(sp)
+}
+
+func (r *spanS) OperationName() string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.Operation
+}
+
+func (r *spanS) TagValue(key string) (interface{}, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	v, ok := r.Tags[key]
+
+	return v, ok
+}
+
+func (r *spanS) onStart() {
+	if r.event != nil {
+		r.event(bt.EventCreate{OperationName: r.Operation})
+	}
+
+	for _, p := range r.tracer.options.SpanProcessors {
+		p.OnStart(r)
+	}
+}
+
+// onFinish runs span processors and returns false if the span should be dropped
+func (r *spanS) onFinish() bool {
+	for _, p := range r.tracer.options.SpanProcessors {
+		if !p.OnFinish(r) {
+			return false
+		}
+	}
+
+	if r.event != nil {
+		r.Lock()
+		raw := r.raw()
+		r.Unlock()
+
+		r.event(bt.EventFinish(raw))
+	}
+
+	return true
+}
+
+// finished returns a copy of the span data. The caller is expected to hold the span lock.
+func (r *spanS) finished() FinishedSpan {
+	sp := FinishedSpan{
+		TraceID:    r.context.TraceID,
+		SpanID:     r.context.SpanID,
+		ParentID:   r.ParentSpanID,
+		Operation:  r.Operation,
+		Kind:       r.getSpanKindTag(),
+		Start:      r.Start,
+		Duration:   r.Duration,
+		Error:      r.Error,
+		ErrorCount: r.Ec,
+	}
+
+	if len(r.Tags) > 0 {
+		sp.Tags = make(ot.Tags, len(r.Tags))
+		for k, v := range r.Tags {
+			sp.Tags[k] = v
+		}
+	}
+
+	if len(r.Logs) > 0 {
+		sp.Logs = make([]ot.LogRecord, len(r.Logs))
+		for i, lr := range r.Logs {
+			sp.Logs[i] = ot.LogRecord{
+				Timestamp: lr.Timestamp,
+				Fields:    append([]otlog.Field(nil), lr.Fields...),
+			}
+		}
+	}
+
+	if len(r.context.Baggage) > 0 {
+		sp.Baggage = make(map[string]string, len(r.context.Baggage))
+		for k, v := range r.context.Baggage {
+			sp.Baggage[k] = v
+		}
+	}
+
+	return sp
+}
+
+// raw converts the span into basictracer.RawSpan passed with the finish event
+func (r *spanS) raw() bt.RawSpan {
+	return bt.RawSpan{
+		Context: bt.SpanContext{
+			TraceID: uint64(r.context.TraceID),
+			SpanID:  uint64(r.context.SpanID),
+			Sampled: r.context.Sampled,
+			Baggage: r.context.Baggage,
+		},
+		ParentSpanID: uint64(r.ParentSpanID),
+		Operation:    r.Operation,
+		Start:        r.Start,
+		Duration:     r.Duration,
+		Tags:         r.Tags,
+		Logs:         r.Logs,
+	}
+}
@@ -0,0 +1,101 @@
+package instana_test
+
+import (
+	"testing"
+
+	instana "github.com/instana/go-sensor"
+	bt "github.com/opentracing/basictracer-go"
+	ext "github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpanProcessors(t *testing.T) {
+	var started, finished []string
+
+	opts := &instana.Options{
+		SpanProcessors: []instana.SpanProcessor{
+			instana.SpanProcessorFuncs{
+				OnStartFunc: func(sp instana.ProcessedSpan) {
+					started = append(started, sp.OperationName())
+					sp.SetTag("tenant", "acme")
+				},
+			},
+			instana.SpanProcessorFuncs{
+				OnFinishFunc: func(sp instana.ProcessedSpan) bool {
+					finished = append(finished, sp.OperationName())
+
+					url, _ := sp.TagValue(string(ext.HTTPUrl))
+					return url != "/healthz"
+				},
+			},
+			instana.SpanProcessorFuncs{
+				OnFinishFunc: func(sp instana.ProcessedSpan) bool {
+					if v, ok := sp.TagValue("password"); ok && v != "" {
+						sp.SetTag("password", "<redacted>")
+					}
+
+					return true
+				},
+			},
+		},
+	}
+
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(opts, recorder)
+
+	sp := tracer.StartSpan("health")
+	sp.SetTag(string(ext.HTTPUrl), "/healthz")
+	sp.Finish()
+
+	sp = tracer.StartSpan("login")
+	sp.SetTag(string(ext.HTTPUrl), "/login")
+	sp.SetTag("password", "secret")
+	sp.Finish()
+
+	assert.Equal(t, []string{"health", "login"}, started)
+	assert.Equal(t, []string{"health", "login"}, finished)
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	assert.Equal(t, "login", spans[0].Data.SDK.Name)
+	assert.Equal(t, "acme", spans[0].Data.SDK.Custom.Tags["tenant"])
+	assert.Equal(t, "<redacted>", spans[0].Data.SDK.Custom.Tags["password"])
+}
+
+func TestSpanEventListener(t *testing.T) {
+	var events []bt.SpanEvent
+
+	opts := &instana.Options{
+		NewSpanEventListener: func() func(bt.SpanEvent) {
+			return func(e bt.SpanEvent) {
+				events = append(events, e)
+			}
+		},
+	}
+
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(opts, recorder)
+
+	sp := tracer.StartSpan("test")
+	sp.SetTag("foo", "bar")
+	sp.SetBaggageItem("key", "value")
+	sp.LogKV("event", "something happened")
+	sp.Finish()
+
+	require.Len(t, events, 5)
+
+	assert.Equal(t, bt.EventCreate{OperationName: "test"}, events[0])
+	assert.Equal(t, bt.EventTag{Key: "foo", Value: "bar"}, events[1])
+	assert.Equal(t, bt.EventBaggage{Key: "key", Value: "value"}, events[2])
+	assert.IsType(t, bt.EventLogFields{}, events[3])
+
+	require.IsType(t, bt.EventFinish{}, events[4])
+	finish := events[4].(bt.EventFinish)
+
+	assert.Equal(t, "test", finish.Operation)
+	assert.Equal(t, "bar", finish.Tags["foo"])
+	assert.Equal(t, "value", finish.Context.Baggage["key"])
+	assert.True(t, finish.Duration >= 0)
+}
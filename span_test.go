@@ -1,16 +1,20 @@
 package instana_test
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"testing"
 	"time"
 
 	instana "github.com/instana/go-sensor"
+	bt "github.com/opentracing/basictracer-go"
 	ot "github.com/opentracing/opentracing-go"
 	ext "github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBasicSpan(t *testing.T) {
@@ -131,9 +135,9 @@ func TestSpanLogFields(t *testing.T) {
 	assert.Equal(t, 1, len(logData), "Unexpected log count")
 
 	for _, v := range logData {
-		assert.Equal(t, "soft error", v["event"], "Wrong or missing log")
-		assert.Equal(t, "cache timeout", v["type"], "Wrong or missing log")
-		assert.Equal(t, 1500, v["waited.millis"], "Wrong or missing log")
+		assert.Equal(t, "soft error", v.Fields["event"], "Wrong or missing log")
+		assert.Equal(t, "cache timeout", v.Fields["type"], "Wrong or missing log")
+		assert.Equal(t, 1500, v.Fields["waited.millis"], "Wrong or missing log")
 	}
 }
 
@@ -162,9 +166,9 @@ func TestSpanLogKVs(t *testing.T) {
 	assert.Equal(t, 1, len(logData), "Unexpected log count")
 
 	for _, v := range logData {
-		assert.Equal(t, "soft error", v["event"], "Wrong or missing log")
-		assert.Equal(t, "cache timeout", v["type"], "Wrong or missing log")
-		assert.Equal(t, 1500, v["waited.millis"], "Wrong or missing log")
+		assert.Equal(t, "soft error", v.Fields["event"], "Wrong or missing log")
+		assert.Equal(t, "cache timeout", v.Fields["type"], "Wrong or missing log")
+		assert.Equal(t, 1500, v.Fields["waited.millis"], "Wrong or missing log")
 	}
 }
 
@@ -189,7 +193,7 @@ func TestOTLogError(t *testing.T) {
 	assert.Equal(t, 1, firstSpan.Ec, "Error count should be 1")
 
 	for _, v := range logData {
-		for sk, sv := range v {
+		for sk, sv := range v.Fields {
 			fmt.Print(v)
 			assert.Equal(t, "error", sk, "Wrong or missing log")
 			assert.Equal(t, "simulated error", sv, "Wrong or missing log")
@@ -219,7 +223,7 @@ func TestSpanErrorLogKV(t *testing.T) {
 	assert.Equal(t, 1, len(logData), "Unexpected log count")
 
 	for _, v := range logData {
-		for sk, sv := range v {
+		for sk, sv := range v.Fields {
 			assert.Equal(t, "error", sk, "Wrong or missing log")
 			assert.Equal(t, "simulated error", sv, "Wrong or missing log")
 		}
@@ -244,7 +248,212 @@ func TestSpanErrorLogFields(t *testing.T) {
 	firstSpan := spans[0]
 
 	logData := firstSpan.Data.SDK.Custom.Logs
-	assert.Equal(t, 1, len(logData), "Unexpected tag count")
+	assert.Equal(t, 2, len(logData), "Unexpected log count")
 	assert.Equal(t, true, firstSpan.Error, "Span should be marked as errored")
 	assert.Equal(t, 2, firstSpan.Ec, "Error count should be 2")
+
+	for _, v := range logData {
+		assert.Equal(t, "simulated error", v.Fields["error"], "Wrong or missing log")
+	}
+}
+
+func TestSpanLogsSameTimestamp(t *testing.T) {
+	opts := instana.Options{LogLevel: instana.Debug, MaxLogsPerSpan: -1}
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&opts, recorder)
+
+	ts := time.Now().Truncate(time.Millisecond)
+
+	span := tracer.StartSpan("test")
+	span.FinishWithOptions(ot.FinishOptions{
+		LogRecords: []ot.LogRecord{
+			{Timestamp: ts, Fields: []log.Field{log.String("error", "first error"), log.Int("attempt", 1)}},
+			{Timestamp: ts, Fields: []log.Field{log.String("error", "second error")}},
+			{Timestamp: ts.Add(100 * time.Microsecond), Fields: []log.Field{log.String("event", "retry")}},
+		},
+	})
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	logData := spans[0].Data.SDK.Custom.Logs
+	require.Len(t, logData, 3)
+
+	expected := []map[string]interface{}{
+		{"error": "first error", "attempt": 1},
+		{"error": "second error"},
+		{"event": "retry"},
+	}
+
+	for i, v := range logData {
+		assert.Equal(t, uint64(ts.UnixNano())/uint64(time.Millisecond), v.Timestamp)
+		assert.Equal(t, expected[i], v.Fields)
+	}
+}
+
+func TestSpanLogsNonSerializableValues(t *testing.T) {
+	type payload struct {
+		Name string
+	}
+
+	opts := instana.Options{LogLevel: instana.Debug}
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&opts, recorder)
+
+	span := tracer.StartSpan("test")
+	span.LogFields(
+		log.Error(errors.New("simulated error")),
+		log.Object("payload", payload{Name: "value"}),
+		log.Object("callback", func() {}),
+		log.Float64("nan", math.NaN()),
+		log.Float32("inf", float32(math.Inf(-1))),
+		log.Float64("ratio", 0.5),
+	)
+	span.Finish()
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 1)
+
+	logData := spans[0].Data.SDK.Custom.Logs
+	require.Len(t, logData, 1)
+
+	assert.Equal(t, "simulated error", logData[0].Fields["error"])
+	assert.Equal(t, json.RawMessage(`{"Name":"value"}`), logData[0].Fields["payload"])
+	assert.IsType(t, "", logData[0].Fields["callback"])
+	assert.Equal(t, "NaN", logData[0].Fields["nan"])
+	assert.Equal(t, "-Inf", logData[0].Fields["inf"])
+	assert.Equal(t, 0.5, logData[0].Fields["ratio"])
+
+	_, err := json.Marshal(spans)
+	assert.NoError(t, err)
+}
+
+func TestSpanMaxLogsPerSpan(t *testing.T) {
+	tests := map[string]struct {
+		maxLogs  int
+		expected []string
+		dropped  int
+	}{
+		"within limit": {
+			maxLogs:  10,
+			expected: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+		},
+		"odd limit": {
+			maxLogs:  5,
+			expected: []string{"0", "1", "", "8", "9"},
+			dropped:  6,
+		},
+		"even limit": {
+			maxLogs:  4,
+			expected: []string{"0", "", "8", "9"},
+			dropped:  7,
+		},
+		"default limit": {
+			expected: []string{"", "9"},
+			dropped:  9,
+		},
+		"no limit": {
+			maxLogs:  -1,
+			expected: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var logs []ot.LogRecord
+
+			opts := &instana.Options{
+				MaxLogsPerSpan: test.maxLogs,
+				NewSpanEventListener: func() func(bt.SpanEvent) {
+					return func(e bt.SpanEvent) {
+						if e, ok := e.(bt.EventFinish); ok {
+							logs = e.Logs
+						}
+					}
+				},
+			}
+			tracer := instana.NewTracerWithEverything(opts, instana.NewTestRecorder())
+
+			sp := tracer.StartSpan("test")
+			for i := 0; i < 10; i++ {
+				sp.LogFields(log.String("message", fmt.Sprint(i)))
+			}
+			sp.Finish()
+
+			require.Len(t, logs, len(test.expected))
+
+			for i, lr := range logs {
+				fields := make(map[string]interface{})
+				for _, f := range lr.Fields {
+					fields[f.Key()] = f.Value()
+				}
+
+				if test.expected[i] == "" {
+					assert.Equal(t, "dropped Span logs", fields["event"])
+					assert.Equal(t, test.dropped, fields["dropped_log_count"])
+					continue
+				}
+
+				assert.Equal(t, test.expected[i], fields["message"])
+			}
+		})
+	}
+}
+
+func TestSpanPool(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+	tracer := instana.NewTracerWithEverything(&instana.Options{EnableSpanPool: true}, recorder)
+
+	for i := 0; i < 10; i++ {
+		parent := tracer.StartSpan("parent")
+		parent.SetBaggageItem("iteration", fmt.Sprint(i))
+
+		child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+		child.SetTag("iteration", i)
+		child.Finish()
+
+		parent.Finish()
+	}
+
+	spans := recorder.GetQueuedSpans()
+	require.Len(t, spans, 20)
+
+	for i := 0; i < 10; i++ {
+		child, parent := spans[2*i], spans[2*i+1]
+
+		assert.Equal(t, "child", child.Data.SDK.Name)
+		assert.Equal(t, i, child.Data.SDK.Custom.Tags["iteration"])
+		assert.Equal(t, map[string]string{"iteration": fmt.Sprint(i)}, child.Data.SDK.Custom.Baggage)
+
+		require.NotNil(t, child.ParentID)
+		assert.Equal(t, parent.SpanID, *child.ParentID)
+		assert.Equal(t, parent.TraceID, child.TraceID)
+
+		assert.Equal(t, "parent", parent.Data.SDK.Name)
+		assert.Nil(t, parent.ParentID)
+	}
+}
+
+func benchmarkStartFinishSpan(b *testing.B, opts *instana.Options) {
+	// the recorder does not keep the spans, so that only the tracer hot path is measured
+	tracer := instana.NewTracerWithEverything(opts, instana.SpanRecorderFunc(func(instana.FinishedSpan) {}))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		parent := tracer.StartSpan("parent")
+		child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+		child.SetTag("key", "value")
+		child.Finish()
+		parent.Finish()
+	}
+}
+
+func BenchmarkStartFinishSpan(b *testing.B) {
+	benchmarkStartFinishSpan(b, &instana.Options{})
+}
+
+func BenchmarkStartFinishSpan_Pooled(b *testing.B) {
+	benchmarkStartFinishSpan(b, &instana.Options{EnableSpanPool: true})
 }
@@ -46,18 +46,18 @@ func (r *tracerS) StartSpan(operationName string, opts ...ot.StartSpanOption) ot
 func (r *tracerS) StartSpanWithOptions(operationName string, opts ot.StartSpanOptions) ot.Span {
 	startTime := opts.StartTime
 	if startTime.IsZero() {
-		startTime = time.Now()
+		startTime = r.options.Clock.Now()
 	}
 
 	tags := opts.Tags
-	span := &spanS{}
+	span := r.newSpan()
 Loop:
 	for _, ref := range opts.References {
 		switch ref.Type {
 		case ot.ChildOfRef, ot.FollowsFromRef:
 			refCtx := ref.ReferencedContext.(SpanContext)
 			span.context.TraceID = refCtx.TraceID
-			span.context.SpanID = randomID()
+			span.context.SpanID = r.options.IDGenerator.NewID()
 			span.context.Sampled = refCtx.Sampled
 			span.ParentSpanID = refCtx.SpanID
 			if l := len(refCtx.Baggage); l > 0 {
@@ -72,7 +72,7 @@ Loop:
 	}
 
 	if span.context.TraceID == 0 {
-		span.context.SpanID = randomID()
+		span.context.SpanID = r.options.IDGenerator.NewID()
 		span.context.TraceID = span.context.SpanID
 		span.context.Sampled = r.options.ShouldSample(span.context.TraceID)
 	}
@@ -87,9 +87,24 @@ func (r *tracerS) startSpanInternal(span *spanS, operationName string, startTime
 	span.Duration = -1
 	span.Tags = tags
 
+	if r.options.NewSpanEventListener != nil {
+		span.event = r.options.NewSpanEventListener()
+	}
+
+	span.onStart()
+
 	return span
 }
 
+// newSpan returns an empty span taken from the pool if span pooling is enabled
+func (r *tracerS) newSpan() *spanS {
+	if r.options.EnableSpanPool {
+		return spanPool.Get().(*spanS)
+	}
+
+	return &spanS{}
+}
+
 func shouldSample(traceID int64) bool {
 	return false
 }
@@ -109,10 +124,50 @@ func NewTracerWithOptions(options *Options) ot.Tracer {
 // NewTracerWithEverything Get a new Tracer with the works.
 func NewTracerWithEverything(options *Options, recorder SpanRecorder) ot.Tracer {
 	InitSensor(options)
+
+	return newTracer(options, recorder)
+}
+
+// NewStandaloneTracer returns a tracer that passes finished spans to the recorder without initializing
+// the sensor, so that no connection to the host agent is made and no metrics are collected. This tracer
+// is meant to be used in tests, see the instanatest package.
+func NewStandaloneTracer(options *Options, recorder SpanRecorder) ot.Tracer {
+	return newTracer(options, recorder)
+}
+
+func newTracer(options *Options, recorder SpanRecorder) *tracerS {
+	if options == nil {
+		options = &Options{}
+	}
+
+	// a negative value disables the limit
+	maxLogs := options.MaxLogsPerSpan
+	switch {
+	case maxLogs == 0:
+		maxLogs = MaxLogsPerSpan
+	case maxLogs < 0:
+		maxLogs = 0
+	}
+
+	clock := options.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	idGen := options.IDGenerator
+	if idGen == nil {
+		idGen = RandomIDGenerator
+	}
+
 	ret := &tracerS{options: TracerOptions{
-		Recorder:       recorder,
-		ShouldSample:   shouldSample,
-		MaxLogsPerSpan: MaxLogsPerSpan}}
+		Clock:                clock,
+		IDGenerator:          idGen,
+		Recorder:             recorder,
+		ShouldSample:         shouldSample,
+		MaxLogsPerSpan:       maxLogs,
+		SpanProcessors:       options.SpanProcessors,
+		EnableSpanPool:       options.EnableSpanPool,
+		NewSpanEventListener: options.NewSpanEventListener}}
 	ret.textPropagator = &textMapPropagator{ret}
 
 	return ret
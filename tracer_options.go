@@ -29,12 +29,19 @@ type TracerOptions struct {
 	// Spans into no-ops. More precisely, tags and log events are silently
 	// discarded. If NewSpanEventListener is set, the callbacks will still fire.
 	TrimUnsampledSpans bool
+	// Clock is used to timestamp spans and their logs. Defaults to SystemClock.
+	Clock Clock
+	// IDGenerator is used to generate trace and span IDs. Defaults to RandomIDGenerator.
+	IDGenerator IDGenerator
 	// Recorder receives Spans which have been finished.
 	Recorder SpanRecorder
 	// NewSpanEventListener can be used to enhance the tracer by effectively
 	// attaching external code to trace events. See NetTraceIntegrator for a
 	// practical example, and event.go for the list of possible events.
 	NewSpanEventListener func() func(bt.SpanEvent)
+	// SpanProcessors are invoked in order for each span when it's started and finished,
+	// before the span is passed to the Recorder. See SpanProcessor for details.
+	SpanProcessors []SpanProcessor
 	// DropAllLogs turns log events on all Spans into no-ops.
 	// If NewSpanEventListener is set, the callbacks will still fire.
 	DropAllLogs bool
@@ -84,8 +91,9 @@ type TracerOptions struct {
 	// after calling Finish by running additional assertions.
 	DebugAssertUseAfterFinish bool
 	// EnableSpanPool enables the use of a pool, so that the tracer reuses spans
-	// after Finish has been called on it. Adds a slight performance gain as it
-	// reduces allocations. However, if you have any use-after-finish race
+	// after Finish has been called on it, along with the structures used to
+	// report them once they have been sent to the agent. Adds a slight performance
+	// gain as it reduces allocations. However, if you have any use-after-finish race
 	// conditions the code may panic.
 	EnableSpanPool bool
 }
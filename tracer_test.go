@@ -1,10 +1,15 @@
 package instana_test
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	instana "github.com/instana/go-sensor"
+	"github.com/instana/go-sensor/instanatest"
+	ot "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	//opentracing "github.com/opentracing/opentracing-go"
 )
 
@@ -21,6 +26,18 @@ func TestTracerAPI(t *testing.T) {
 	assert.NotNil(t, tracer, "NewTracerWithOptions returned nil")
 }
 
+func TestNewTracerWithEverything_NilOptions(t *testing.T) {
+	recorder := instana.NewTestRecorder()
+
+	var tracer ot.Tracer
+	require.NotPanics(t, func() {
+		tracer = instana.NewTracerWithEverything(nil, recorder)
+	})
+
+	tracer.StartSpan("test").Finish()
+	assert.Equal(t, 1, recorder.QueuedSpansCount())
+}
+
 func TestTracerBasics(t *testing.T) {
 	opts := instana.Options{LogLevel: instana.Debug}
 	recorder := instana.NewTestRecorder()
@@ -33,3 +50,58 @@ func TestTracerBasics(t *testing.T) {
 	spans := recorder.GetQueuedSpans()
 	assert.Equal(t, len(spans), 1)
 }
+
+// steppingClock moves forward by step each time the current time is requested
+type steppingClock struct {
+	instana.Clock
+
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now
+	c.now = c.now.Add(c.step)
+
+	return now
+}
+
+func TestTracerClockAndIDGenerator(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var lastID int64
+	tracer, recorder := instanatest.NewTracerWithOptions(&instana.Options{
+		Clock: &steppingClock{Clock: instana.SystemClock, now: start, step: time.Millisecond},
+		IDGenerator: instana.IDGeneratorFunc(func() int64 {
+			lastID++
+			return lastID
+		}),
+	})
+
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", ot.ChildOf(parent.Context()))
+	child.LogKV("event", "test")
+	child.Finish()
+	parent.Finish()
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, int64(1), spans[1].TraceID)
+	assert.Equal(t, int64(1), spans[1].SpanID)
+	assert.Equal(t, start, spans[1].Start)
+	assert.Equal(t, 4*time.Millisecond, spans[1].Duration)
+
+	assert.Equal(t, int64(1), spans[0].TraceID)
+	assert.Equal(t, int64(2), spans[0].SpanID)
+	assert.Equal(t, int64(1), spans[0].ParentID)
+	assert.Equal(t, start.Add(time.Millisecond), spans[0].Start)
+	assert.Equal(t, 2*time.Millisecond, spans[0].Duration)
+
+	require.Len(t, spans[0].Logs, 1)
+	assert.Equal(t, start.Add(2*time.Millisecond), spans[0].Logs[0].Timestamp)
+}
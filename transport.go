@@ -0,0 +1,194 @@
+package instana
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Transport delivers the data collected by the sensor to Instana. The default implementation
+// talks to the host agent (or the serverless endpoint) over HTTP, a custom one can be provided
+// via Options.Transport.
+//
+// The payloads passed to a Transport are opaque: their concrete types are unexported and may
+// change between releases. They are only guaranteed to be serializable with encoding/json into
+// the JSON documents expected by Instana. Implementations that need to inspect the data should
+// marshal the payload and decode the result, the way InMemoryTransport does.
+type Transport interface {
+	// Announce registers the process using the discovery payload and decodes the
+	// JSON response into resp
+	Announce(ctx context.Context, discovery interface{}, resp interface{}) error
+	// Ping checks whether the announced process is ready to send data
+	Ping(ctx context.Context) error
+	// SendSpans sends a batch of finished spans, which is serialized as a JSON array
+	SendSpans(ctx context.Context, spans interface{}) error
+	// SendMetrics sends the snapshot and metrics payload, which is serialized as a JSON object
+	SendMetrics(ctx context.Context, data interface{}) error
+	// SendEvent sends an event, which is serialized as a JSON object
+	SendEvent(ctx context.Context, event interface{}) error
+}
+
+// agentTransport is the default Transport that sends data to the host agent
+type agentTransport struct {
+	agent *agentS
+}
+
+func (t *agentTransport) Announce(ctx context.Context, discovery interface{}, resp interface{}) error {
+	_, err := t.agent.fullRequestResponseContext(ctx, t.agent.makeURL(agentDiscoveryURL), "PUT", discovery, resp, "")
+	return err
+}
+
+func (t *agentTransport) Ping(ctx context.Context) error {
+	_, err := t.agent.fullRequestResponseContext(ctx, t.agent.makeURL(agentDataURL), "HEAD", nil, nil, "")
+	return err
+}
+
+func (t *agentTransport) SendSpans(ctx context.Context, spans interface{}) error {
+	if ss, ok := spans.([]jsonSpan); ok {
+		return t.agent.sendSpans(ctx, t.agent.makeURL(agentTracesURL), ss)
+	}
+
+	_, err := t.agent.requestContext(ctx, t.agent.makeURL(agentTracesURL), "POST", spans)
+	return err
+}
+
+func (t *agentTransport) SendMetrics(ctx context.Context, data interface{}) error {
+	_, err := t.agent.requestContext(ctx, t.agent.makeURL(agentDataURL), "POST", data)
+	return err
+}
+
+func (t *agentTransport) SendEvent(ctx context.Context, event interface{}) error {
+	_, err := t.agent.requestContext(ctx, t.agent.makeURL(agentEventURL), "POST", event)
+	return err
+}
+
+// InMemoryTransport is a Transport that keeps all sent payloads in memory. It is
+// meant to be used in tests to verify the data reported by the sensor. Payloads are
+// stored as decoded JSON objects, the same way the agent would receive them.
+type InMemoryTransport struct {
+	mu sync.Mutex
+
+	// AnnounceResponse is returned in response to the announce request
+	AnnounceResponse interface{}
+
+	err         error
+	discoveries []map[string]interface{}
+	spans       []map[string]interface{}
+	metrics     []map[string]interface{}
+	events      []map[string]interface{}
+}
+
+// NewInMemoryTransport returns a new InMemoryTransport that accepts announcements
+// with the provided process ID
+func NewInMemoryTransport(pid int) *InMemoryTransport {
+	return &InMemoryTransport{
+		AnnounceResponse: map[string]interface{}{"pid": pid},
+	}
+}
+
+// Announce records the discovery payload and decodes AnnounceResponse into resp
+func (t *InMemoryTransport) Announce(ctx context.Context, discovery interface{}, resp interface{}) error {
+	if err := t.recordObject(&t.discoveries, discovery); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return decodePayload(t.AnnounceResponse, resp)
+}
+
+// SetError makes all subsequent calls fail with err until it is reset with nil
+func (t *InMemoryTransport) SetError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.err = err
+}
+
+// Ping returns the error set with SetError, if any
+func (t *InMemoryTransport) Ping(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err
+}
+
+// SendSpans records each span of the batch
+func (t *InMemoryTransport) SendSpans(ctx context.Context, spans interface{}) error {
+	var decoded []map[string]interface{}
+	if err := decodePayload(spans, &decoded); err != nil {
+		return err
+	}
+
+	return t.record(&t.spans, decoded...)
+}
+
+// SendMetrics records the metrics payload
+func (t *InMemoryTransport) SendMetrics(ctx context.Context, data interface{}) error {
+	return t.recordObject(&t.metrics, data)
+}
+
+// SendEvent records the event payload
+func (t *InMemoryTransport) SendEvent(ctx context.Context, event interface{}) error {
+	return t.recordObject(&t.events, event)
+}
+
+// Spans returns all recorded spans
+func (t *InMemoryTransport) Spans() []map[string]interface{} {
+	return t.recorded(&t.spans)
+}
+
+// Metrics returns all recorded metrics payloads
+func (t *InMemoryTransport) Metrics() []map[string]interface{} {
+	return t.recorded(&t.metrics)
+}
+
+// Events returns all recorded event payloads
+func (t *InMemoryTransport) Events() []map[string]interface{} {
+	return t.recorded(&t.events)
+}
+
+// Discoveries returns all recorded announce payloads
+func (t *InMemoryTransport) Discoveries() []map[string]interface{} {
+	return t.recorded(&t.discoveries)
+}
+
+func (t *InMemoryTransport) recordObject(dst *[]map[string]interface{}, v interface{}) error {
+	var decoded map[string]interface{}
+	if err := decodePayload(v, &decoded); err != nil {
+		return err
+	}
+
+	return t.record(dst, decoded)
+}
+
+func (t *InMemoryTransport) record(dst *[]map[string]interface{}, v ...map[string]interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+
+	*dst = append(*dst, v...)
+
+	return nil
+}
+
+func (t *InMemoryTransport) recorded(src *[]map[string]interface{}) []map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]map[string]interface{}(nil), *src...)
+}
+
+// decodePayload converts the payload into dst the way it would be seen by the receiver
+func decodePayload(payload interface{}, dst interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,66 @@
+package instana
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryTransport(t *testing.T) {
+	InitSensor(&Options{})
+
+	tr := NewInMemoryTransport(1234)
+
+	// Replace the global sensor for the duration of this test
+	prevSensor := sensor
+	defer func() { sensor = prevSensor }()
+
+	sensor = &sensorS{}
+	sensor.setOptions(&Options{Service: "test", MetricsInterval: time.Hour, Transport: tr})
+	sensor.configureServiceName()
+	sensor.agent = sensor.initAgent()
+	sensor.meter = sensor.initMeter()
+
+	require.Eventually(t, sensor.agent.canSend, time.Second, 10*time.Millisecond, "agent never became ready")
+	assert.Equal(t, "1234", sensor.agent.from.PID)
+	require.Len(t, tr.Discoveries(), 1)
+	assert.Equal(t, float64(os.Getpid()), tr.Discoveries()[0]["pid"])
+
+	recorder := NewTestRecorder()
+	sensor.addRecorder(recorder)
+
+	tracer := NewTracerWithEverything(sensor.options, recorder)
+	tracer.StartSpan("test").Finish()
+
+	require.NoError(t, Flush(context.Background()))
+
+	require.Len(t, tr.Spans(), 1)
+	assert.Equal(t, "sdk", tr.Spans()[0]["n"])
+	assert.Equal(t, "test", tr.Spans()[0]["data"].(map[string]interface{})["sdk"].(map[string]interface{})["name"])
+
+	require.Len(t, tr.Metrics(), 1)
+	assert.Equal(t, float64(1234), tr.Metrics()[0]["pid"])
+
+	tr.SetError(errors.New("agent unavailable"))
+	tracer.StartSpan("test").Finish()
+	assert.Error(t, Flush(context.Background()))
+	assert.Len(t, tr.Spans(), 1)
+}
+
+// recordedEvent decodes the i-th event payload recorded by the transport
+func recordedEvent(t *testing.T, tr *InMemoryTransport, i int) *EventData {
+	t.Helper()
+
+	events := tr.Events()
+	require.True(t, i < len(events), "event %d has not been recorded", i)
+
+	e := &EventData{}
+	require.NoError(t, decodePayload(events[i], e))
+
+	return e
+}
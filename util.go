@@ -3,6 +3,7 @@ package instana
 import (
 	"bufio"
 	"bytes"
+	cryptorand "crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -15,15 +16,58 @@ import (
 	"time"
 )
 
-var (
-	seededIDGen  = rand.New(rand.NewSource(time.Now().UnixNano()))
-	seededIDLock sync.Mutex
-)
+// idSources is the pool of random sources used to generate IDs. Each source is seeded from crypto/rand,
+// so that the processes started at the same time, i.e. forked workers, don't generate the same sequences.
+// Pooling the sources instead of sharing a single one avoids lock contention under high span rates.
+var idSources = sync.Pool{
+	New: func() interface{} {
+		return rand.New(rand.NewSource(cryptoSeed()))
+	},
+}
+
+// cryptoSeed returns a seed read from crypto/rand, falling back to the current time if it's unavailable
+func cryptoSeed() int64 {
+	var b [8]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		log.warn("failed to read random seed, falling back to the current time:", err)
+		return time.Now().UnixNano()
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// IDGenerator generates trace and span IDs. Generated IDs are expected to be unique and non-zero.
+// A custom IDGenerator can be provided via Options.IDGenerator, i.e. to get predictable IDs in tests.
+type IDGenerator interface {
+	NewID() int64
+}
+
+// IDGeneratorFunc is an adapter to use a function as an IDGenerator
+type IDGeneratorFunc func() int64
+
+// NewID calls f()
+func (f IDGeneratorFunc) NewID() int64 {
+	return f()
+}
+
+// RandomIDGenerator is the IDGenerator used by default. It returns random positive IDs.
+var RandomIDGenerator IDGenerator = IDGeneratorFunc(randomID)
 
 func randomID() int64 {
-	seededIDLock.Lock()
-	defer seededIDLock.Unlock()
-	return int64(seededIDGen.Int63())
+	src := idSources.Get().(*rand.Rand)
+	id := nonZeroID(src)
+	idSources.Put(src)
+
+	return id
+}
+
+// nonZeroID returns the next positive number from src, since zero ID means that there is no trace
+func nonZeroID(src *rand.Rand) int64 {
+	for {
+		if id := src.Int63(); id != 0 {
+			return id
+		}
+	}
 }
 
 // ID2Header converts an Instana ID to a value that can be used in
@@ -3,8 +3,11 @@ package instana
 import (
 	"errors"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -24,6 +27,80 @@ func TestGeneratedIDRange(t *testing.T) {
 	}
 }
 
+type sequenceSource struct {
+	values []int64
+}
+
+func (s *sequenceSource) Int63() int64 {
+	v := s.values[0]
+	s.values = s.values[1:]
+
+	return v
+}
+
+func (s *sequenceSource) Seed(int64) {}
+
+func TestNonZeroID(t *testing.T) {
+	src := rand.New(&sequenceSource{values: []int64{0, 0, 42}})
+	assert.Equal(t, int64(42), nonZeroID(src))
+}
+
+func TestRandomIDConcurrent(t *testing.T) {
+	const goroutines, perGoroutine = 8, 1000
+
+	ids := make(chan int64, goroutines*perGoroutine)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < perGoroutine; j++ {
+				ids <- randomID()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int64]struct{}, goroutines*perGoroutine)
+	for id := range ids {
+		assert.NotZero(t, id)
+		seen[id] = struct{}{}
+	}
+
+	assert.Len(t, seen, goroutines*perGoroutine)
+}
+
+func TestCryptoSeed(t *testing.T) {
+	assert.NotEqual(t, cryptoSeed(), cryptoSeed())
+}
+
+func BenchmarkRandomID(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			randomID()
+		}
+	})
+}
+
+// BenchmarkRandomID_SharedSource measures the previous implementation using a single
+// time-seeded source guarded by a mutex for comparison
+func BenchmarkRandomID_SharedSource(b *testing.B) {
+	var mu sync.Mutex
+	src := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			src.Int63()
+			mu.Unlock()
+		}
+	})
+}
+
 func TestIDConversionBackForth(t *testing.T) {
 	maxID := int64(9223372036854775807)
 	minID := int64(-9223372036854775808)